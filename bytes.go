@@ -0,0 +1,178 @@
+package lua
+
+// #include "lua.h"
+// extern int go_bytes_get_wrap(lua_State *ctx);
+// extern int go_bytes_set_wrap(lua_State *ctx);
+// extern int go_bytes_tostring_wrap(lua_State *ctx);
+// extern int go_bytes_sub_wrap(lua_State *ctx);
+// extern int go_obj_len_wrap(lua_State *ctx);
+// extern int go_obj_free_wrap(lua_State *ctx);
+import "C"
+import (
+	"unsafe"
+	"fmt"
+)
+
+// Bytes is a []byte pushed to Lua as a byte buffer instead of a string. In Lua,
+// a byte buffer `buf` supports `buf[i]`, `buf[i] = byte`, `#buf`, `buf:sub(i, j)`
+// and `tostring(buf)`. A Go function receives the original []byte if a byte buffer
+// is passed as its []byte argument, so Lua can fill a buffer shared with Go in place.
+type Bytes []byte
+
+var (
+	goBytesMeta = "goBytesMeta\x00"
+	goBytesROMeta = "goBytesROMeta\x00" // goBytesMeta of read-only byte buffers
+)
+
+func registerBytesMetatable(ctx *C.lua_State) {
+	bytesMethods := []*metaMethod{
+		{name: __index, method: (C.lua_CFunction)(C.go_bytes_get_wrap)},
+		{name: __newindex, method: (C.lua_CFunction)(C.go_bytes_set_wrap)},
+		{name: __len, method: (C.lua_CFunction)(C.go_obj_len_wrap)},
+		{name: __tostring, method: (C.lua_CFunction)(C.go_bytes_tostring_wrap)},
+		{name: __gc, method: (C.lua_CFunction)(C.go_obj_free_wrap)},
+	}
+	registerMetatable(ctx, goBytesMeta, bytesMethods...)
+	registerMetatable(ctx, goBytesROMeta, bytesMethods...)
+}
+
+func getBytes(ctx *C.lua_State, idx C.int) (b Bytes, ok bool) {
+	if C.lua_type(ctx, idx) != C.LUA_TUSERDATA {
+		return
+	}
+	v, o := getTargetValue(ctx, idx)
+	if !o {
+		return
+	}
+	b, ok = v.(Bytes)
+	return
+}
+
+//export go_bytes_get
+func go_bytes_get(ctx *C.lua_State) C.int {
+	// [ 1 ] bytes
+	// [ 2 ] index or method name
+	b, ok := getBytes(ctx, 1)
+	if !ok {
+		C.lua_pushnil(ctx)
+		return 1
+	}
+	if C.lua_type(ctx, 2) == C.LUA_TSTRING {
+		switch C.GoString(C.lua_tolstring(ctx, 2, (*C.ulong)(unsafe.Pointer(nil)))) {
+		case "sub":
+			C.lua_pushcclosure(ctx, (C.lua_CFunction)(C.go_bytes_sub_wrap), 0)
+		case "len":
+			C.lua_pushcclosure(ctx, (C.lua_CFunction)(C.go_obj_len_wrap), 0)
+		default:
+			C.lua_pushnil(ctx)
+		}
+		return 1
+	}
+	i, err := getBytesIndex(ctx, len(b))
+	if err != nil {
+		C.lua_pushnil(ctx)
+		return 1
+	}
+	C.lua_pushinteger(ctx, C.lua_Integer(b[i]))
+	return 1
+}
+
+//export go_bytes_set
+func go_bytes_set(ctx *C.lua_State) C.int {
+	// [ 1 ] bytes
+	// [ 2 ] index
+	// [ 3 ] byte
+	b, ok := getBytes(ctx, 1)
+	if !ok {
+		return luaError(ctx, "byte buffer expected")
+	}
+	if isReadOnlyProxy(ctx, 1) {
+		return readOnlyError(ctx)
+	}
+	i, err := getBytesIndex(ctx, len(b))
+	if err != nil {
+		return luaError(ctx, err.Error())
+	}
+	var isNum C.int
+	v := int64(C.lua_tointegerx(ctx, 3, &isNum))
+	if isNum == 0 || v < 0 || v > 255 {
+		return luaError(ctx, "byte value (0~255) expected")
+	}
+	b[i] = byte(v)
+	return 0
+}
+
+//export go_bytes_tostring
+func go_bytes_tostring(ctx *C.lua_State) C.int {
+	// [ 1 ] bytes
+	b, ok := getBytes(ctx, 1)
+	if !ok {
+		return luaError(ctx, "byte buffer expected")
+	}
+	pushBytes(ctx, b)
+	return 1
+}
+
+//export go_bytes_sub
+func go_bytes_sub(ctx *C.lua_State) C.int {
+	// [ 1 ] bytes
+	// [ 2 ] i, default 1
+	// [ 3 ] j, default -1
+	b, ok := getBytes(ctx, 1)
+	if !ok {
+		return luaError(ctx, "byte buffer expected")
+	}
+	l := int64(len(b))
+	i, j := int64(1), int64(-1)
+	if C.lua_type(ctx, 2) > C.LUA_TNIL {
+		i = int64(C.lua_tointegerx(ctx, 2, (*C.int)(unsafe.Pointer(nil))))
+	}
+	if C.lua_type(ctx, 3) > C.LUA_TNIL {
+		j = int64(C.lua_tointegerx(ctx, 3, (*C.int)(unsafe.Pointer(nil))))
+	}
+	// the same as string.sub()
+	if i < 0 {
+		i = l + i + 1
+	}
+	if j < 0 {
+		j = l + j + 1
+	}
+	if i < 1 {
+		i = 1
+	}
+	if j > l {
+		j = l
+	}
+	if i > j {
+		pushString(ctx, "")
+		return 1
+	}
+	pushBytes(ctx, b[i-1:j])
+	return 1
+}
+
+func getBytesIndex(ctx *C.lua_State, l int) (i int, err error) {
+	var isNum C.int
+	i = int(C.lua_tointegerx(ctx, 2, &isNum))
+	if isNum == 0 {
+		err = fmt.Errorf("integer expected for byte buffer")
+		return
+	}
+	if i < 0 {
+		i = l + i + 1
+	}
+	if i < 1 || i > l {
+		err = fmt.Errorf("index out of range")
+		return
+	}
+	i -= 1 // go is 0-based
+	return
+}
+
+func pushBytes(ctx *C.lua_State, b []byte) {
+	if len(b) == 0 {
+		pushString(ctx, "")
+		return
+	}
+	C.lua_pushlstring(ctx, (*C.char)(unsafe.Pointer(&b[0])), C.size_t(len(b)))
+}
@@ -0,0 +1,39 @@
+package lua
+
+import (
+	"testing"
+)
+
+func TestBytes(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := Bytes("hello")
+	var got []byte
+	err = ctx.LoadScript(`
+		assert(#buf == 5 and buf:len() == 5)
+		assert(buf[1] == 104 and buf[-1] == 111 and buf[6] == nil)
+		assert(buf:sub(2, 3) == "el" and buf:sub(-2) == "lo" and buf:sub(4, 2) == "")
+		assert(tostring(buf) == "hello")
+		buf[1] = 72
+		fill(buf)
+		assert(not pcall(function() buf[6] = 1 end))
+		assert(not pcall(function() buf[1] = 256 end))
+		assert(not pcall(function() buf.x = 1 end))
+		keep("str")
+	`, map[string]interface{}{
+		"buf": buf,
+		"fill": func(b []byte) { b[4] = '!' },
+		"keep": func(b []byte) { got = b },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "Hell!" {
+		t.Fatalf("buffer not shared, got %q", buf)
+	}
+	if string(got) != "str" {
+		t.Fatalf("strings are still converted to []byte, got %q", got)
+	}
+}
@@ -0,0 +1,45 @@
+package lua
+
+// #include "lua.h"
+// extern void cancelHook(lua_State *L, lua_Debug *ar);
+import "C"
+import (
+	"context"
+)
+
+// WithContext sets the Go context of the Lua context. Once goCtx is done, running
+// scripts fail with the error of goCtx, and so does the I/O of modules such as http.
+func WithContext(goCtx context.Context) Option {
+	return func(o *options) {
+		o.goCtx = goCtx
+	}
+}
+
+// the Go context is checked every cancelCheckCount instructions
+const cancelCheckCount = 1000
+
+func setCancelHook(ctx *C.lua_State) {
+	if env := getCtxEnv(ctx); env != nil && env.opts.goCtx != nil {
+		// coroutines inherit the hook from the thread creating them
+		C.lua_sethook(ctx, (C.lua_Hook)(C.cancelHook), C.LUA_MASKCOUNT, cancelCheckCount)
+	}
+}
+
+// getGoContext returns the Go context set by WithContext(), or context.Background().
+func getGoContext(ctx *C.lua_State) context.Context {
+	if env := getCtxEnv(ctx); env != nil && env.opts.goCtx != nil {
+		return env.opts.goCtx
+	}
+	return context.Background()
+}
+
+// called by cancelHook(), the error is pushed and 1 is returned if the Go context is done.
+//export go_ctx_canceled
+func go_ctx_canceled(ctx *C.lua_State) C.int {
+	err := getGoContext(ctx).Err()
+	if err == nil {
+		return 0
+	}
+	luaError(ctx, err.Error())
+	return 1
+}
@@ -0,0 +1,141 @@
+package lua
+
+// #include <stdint.h>
+// #include <pthread.h>
+// #include "lua.h"
+// static void setMainState(lua_State *L) {
+//	*(lua_State **)lua_getextraspace(L) = L;
+// }
+// static lua_State *getMainState(lua_State *L) {
+//	return *(lua_State **)lua_getextraspace(L);
+// }
+// static uintptr_t threadId(void) {
+//	return (uintptr_t)pthread_self();
+// }
+import "C"
+import (
+	"bufio"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// ctxEnv is the state shared by a LuaContext and the handles (LuaTable, ...)
+// referring to values living in it. The lua_State is closed only after the
+// context and all the handles are released.
+type ctxEnv struct {
+	c *C.lua_State
+	mu *ctxLock
+	refs int
+	closed bool
+	opts options
+	traceback string // traceback of the last error, see protectedCall()
+	stdin *bufio.Reader // reader of options.stdin
+}
+
+var (
+	envLock = &sync.Mutex{}
+	envs = make(map[uintptr]*ctxEnv)
+)
+
+func newCtxEnv(ctx *C.lua_State) *ctxEnv {
+	C.setMainState(ctx)
+	env := &ctxEnv{
+		c: ctx,
+		mu: &ctxLock{},
+		refs: 1,
+	}
+
+	envLock.Lock()
+	defer envLock.Unlock()
+	envs[uintptr(unsafe.Pointer(ctx))] = env
+	return env
+}
+
+// getCtxEnv finds the env of a lua_State, which may be a coroutine of the main state.
+func getCtxEnv(ctx *C.lua_State) *ctxEnv {
+	mainState := C.getMainState(ctx)
+
+	envLock.Lock()
+	defer envLock.Unlock()
+	return envs[uintptr(unsafe.Pointer(mainState))]
+}
+
+// getCtxPtrStore finds the store of Go values of a lua_State, which may be a coroutine
+// of the main state.
+func getCtxPtrStore(ctx *C.lua_State) *ptrStore {
+	return getPtrStore(uintptr(unsafe.Pointer(C.getMainState(ctx))))
+}
+
+// retain is called with env.mu held, as a handle is created from a value on the stack.
+func (env *ctxEnv) retain() {
+	env.refs += 1
+}
+
+func (env *ctxEnv) release() {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	env.releaseLocked()
+}
+
+// releaseLocked is release() with env.mu held.
+func (env *ctxEnv) releaseLocked() {
+	env.refs -= 1
+	if env.refs > 0 || env.closed {
+		return
+	}
+	env.closed = true
+
+	c := env.c
+	C.lua_close(c)
+	delPtrStore((uintptr(unsafe.Pointer(c))))
+
+	envLock.Lock()
+	defer envLock.Unlock()
+	delete(envs, uintptr(unsafe.Pointer(c)))
+}
+
+// ctxLock is the mutex of a context. A Go function called by Lua runs with the context
+// locked by the caller of Lua, on the same OS thread, as a goroutine in a cgo callback
+// is locked to its thread. enterGo() marks the thread, so that the Go function can call
+// back into Lua through a handle without locking the context again.
+type ctxLock struct {
+	mu sync.Mutex
+	thread uintptr // the thread running the Go functions called by Lua, 0 if none
+	calls int // number of the Go functions called by Lua running
+	nested int // number of Lock() skipped on the marked thread
+}
+
+func (l *ctxLock) Lock() {
+	if t := atomic.LoadUintptr(&l.thread); t != 0 && t == currentThread() {
+		l.nested += 1
+		return
+	}
+	l.mu.Lock()
+}
+
+func (l *ctxLock) Unlock() {
+	if l.nested > 0 {
+		l.nested -= 1
+		return
+	}
+	l.mu.Unlock()
+}
+
+// enterGo is called with the lock held, before Lua calls a Go function.
+func (l *ctxLock) enterGo() {
+	l.calls += 1
+	atomic.StoreUintptr(&l.thread, currentThread())
+}
+
+// exitGo is called after the Go function called by Lua returns.
+func (l *ctxLock) exitGo() {
+	l.calls -= 1
+	if l.calls == 0 {
+		atomic.StoreUintptr(&l.thread, 0)
+	}
+}
+
+func currentThread() uintptr {
+	return uintptr(C.threadId())
+}
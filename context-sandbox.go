@@ -0,0 +1,54 @@
+package lua
+
+// #include "lua.h"
+// #include "lualib.h"
+// #include "lauxlib.h"
+import "C"
+
+// WithSafeLibs opens only the Lua libraries without access to the system: base,
+// coroutine, string, table, math and utf8. `dofile` and `loadfile` are removed,
+// and `load` only loads text chunks.
+func WithSafeLibs() Option {
+	return func(o *options) {
+		o.safeLibs = true
+	}
+}
+
+var safeLibs = []struct {
+	name string
+	open C.lua_CFunction
+}{
+	{"_G\x00", (C.lua_CFunction)(C.luaopen_base)},
+	{"coroutine\x00", (C.lua_CFunction)(C.luaopen_coroutine)},
+	{"string\x00", (C.lua_CFunction)(C.luaopen_string)},
+	{"table\x00", (C.lua_CFunction)(C.luaopen_table)},
+	{"math\x00", (C.lua_CFunction)(C.luaopen_math)},
+	{"utf8\x00", (C.lua_CFunction)(C.luaopen_utf8)},
+}
+
+const safeBase = `
+dofile = nil
+loadfile = nil
+local load = load
+_G.load = function(chunk, name, mode, ...)
+	if select("#", ...) > 0 then
+		return load(chunk, name, "t", ...)
+	end
+	return load(chunk, name, "t") -- the globals are the env of the chunk
+end
+`
+
+func openLibs(ctx *C.lua_State) (err error) {
+	if env := getCtxEnv(ctx); env == nil || !env.opts.safeLibs {
+		C.luaL_openlibs(ctx)
+		return
+	}
+	var name *C.char
+	for i := range safeLibs {
+		lib := &safeLibs[i]
+		getStrPtr(&lib.name, &name)
+		C.luaL_requiref(ctx, name, lib.open, 1) // [ lib ]
+		C.lua_settop(ctx, -2) // [ ]
+	}
+	return loadPreludeScript(ctx, safeBase)
+}
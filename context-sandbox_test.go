@@ -0,0 +1,27 @@
+package lua
+
+import (
+	"testing"
+)
+
+func TestSafeLibs(t *testing.T) {
+	ctx, err := NewContext(WithSafeLibs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.LoadScript(`
+		assert(io == nil and os == nil and package == nil and debug == nil)
+		assert(dofile == nil and loadfile == nil)
+		assert(string.upper("a") == "A" and math.max(1, 2) == 2 and utf8.char(65) == "A")
+
+		x = 1
+		assert(load("return x")() == 1)
+		assert(load("return x", "chunk", "t", {x = 2})() == 2)
+		assert(not pcall(load("return x", "chunk", "t", nil))) -- an explicit nil env is kept
+		local f, err = load(string.dump(function() end), "chunk", "b")
+		assert(f == nil and err:find("binary"))
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -8,9 +8,6 @@ package lua
 static int doString(lua_State *L, const char *str) {
 	return luaL_dostring(L, str);
 }
-static int doFile(lua_State *L, const char *filename) {
-	return luaL_dofile(L, filename);
-}
 static void popN(lua_State *L, int n) {
 	lua_pop(L, n);
 }
@@ -20,42 +17,102 @@ static void pushGlobal(lua_State *L) {
 */
 import "C"
 import (
+	"context"
+	"io"
+	"log/slog"
 	"reflect"
 	"unsafe"
 	"fmt"
-	"sync"
 	"runtime"
 )
 
 type LuaContext struct {
-	c *C.lua_State
-	mu *sync.Mutex
+	*ctxEnv
+}
+
+// Option configures a LuaContext created by NewContext.
+type Option func(*options)
+
+type options struct {
+	tablePolicy TablePolicy
+	readOnly bool
+	traceback bool
+	unknownFields UnknownFieldPolicy
+	stdout io.Writer
+	stderr io.Writer
+	stdin io.Reader
+	logger *slog.Logger
+	modules []*Module
+	goCtx context.Context
+	safeLibs bool
+}
+
+// WithTablePolicy sets the policy of converting Lua tables to Go values.
+func WithTablePolicy(policy TablePolicy) Option {
+	return func(o *options) {
+		o.tablePolicy = policy
+	}
+}
+
+// WithReadOnly makes all the Go values passed to Lua read-only, see ReadOnly().
+func WithReadOnly() Option {
+	return func(o *options) {
+		o.readOnly = true
+	}
 }
 
-func NewContext() (*LuaContext, error) {
+func NewContext(opts ...Option) (*LuaContext, error) {
 	ctx := C.luaL_newstate()
 	if ctx == (*C.lua_State)(unsafe.Pointer(nil)) {
 		return nil, fmt.Errorf("failed to create context")
 	}
-	loadPreludeModules(ctx)
 	c := &LuaContext {
-		c: ctx,
-		mu: &sync.Mutex{},
+		ctxEnv: newCtxEnv(ctx),
+	}
+	for _, opt := range opts {
+		opt(&c.opts)
 	}
+	if err := loadPreludeModules(ctx); err != nil {
+		c.release()
+		return nil, err
+	}
+	setCancelHook(ctx)
 	runtime.SetFinalizer(c, freeLuaContext)
 	return c, nil
 }
 
 func freeLuaContext(ctx *LuaContext) {
-	c := ctx.c
-	C.lua_close(c)
-	delPtrStore((uintptr(unsafe.Pointer(c))))
+	// the lua_State is closed after all handles are released
+	ctx.release()
 	// fmt.Printf("context freed\n")
 }
 
-func loadPreludeModules(ctx *C.lua_State) {
-	C.luaL_openlibs(ctx)
+func loadPreludeModules(ctx *C.lua_State) (err error) {
+	if err = openLibs(ctx); err != nil {
+		return
+	}
 	registerGoMetatables(ctx)
+	registerChanModule(ctx)
+	registerAsyncHelper(ctx)
+	registerStdio(ctx)
+	registerLogModule(ctx)
+	registerTimeMetatables(ctx)
+	if err = loadPreludeScript(ctx, arrayHelper); err != nil {
+		return
+	}
+	openModules(ctx)
+	return
+}
+
+func loadPreludeScript(ctx *C.lua_State, script string) (err error) {
+	cstr := C.CString(script)
+	defer C.free(unsafe.Pointer(cstr))
+
+	if C.doString(ctx, cstr) != 0 {
+		err = fmt.Errorf("failed to load prelude: %s", C.GoString(C.lua_tolstring(ctx, -1, (*C.ulong)(unsafe.Pointer(nil)))))
+		C.popN(ctx, 1) // [ ]
+	}
+	return
 }
 
 func (ctx *LuaContext) LoadScript(script string, env map[string]interface{}) (err error) {
@@ -68,10 +125,9 @@ func (ctx *LuaContext) LoadScript(script string, env map[string]interface{}) (er
 	cstr := C.CString(script)
 	defer C.free(unsafe.Pointer(cstr))
 
-	if C.doString(c, cstr) == 0 {
-		return
+	if C.luaL_loadstring(c, cstr) != C.LUA_OK || protectedCall(c, 0, 0) != C.LUA_OK {
+		err = fmt.Errorf("failed to loadScript: %w", popLuaError(c))
 	}
-	err = fmt.Errorf("failed to loadScript: %s", C.GoString(C.lua_tolstring(c, -1, (*C.ulong)(unsafe.Pointer(nil)))))
 	return
 }
 
@@ -85,11 +141,9 @@ func (ctx *LuaContext) LoadFile(scriptFile string, env map[string]interface{}) (
 	cstr := C.CString(scriptFile)
 	defer C.free(unsafe.Pointer(cstr))
 
-	if C.doFile(c, cstr) == 0 {
-		return
+	if C.luaL_loadfilex(c, cstr, nil) != C.LUA_OK || protectedCall(c, 0, 0) != C.LUA_OK {
+		err = fmt.Errorf("failed to loadFile: %w", popLuaError(c))
 	}
-
-	err = fmt.Errorf("failed to loadFile: %s", C.GoString(C.lua_tolstring(c, -1, (*C.ulong)(unsafe.Pointer(nil)))))
 	return
 }
 
@@ -0,0 +1,330 @@
+package lua
+
+// #include <stdlib.h>
+// #include "lua.h"
+// #include "lauxlib.h"
+// static void popN(lua_State *L, int n);
+// extern int go_chan_compile_wrap(lua_State *ctx);
+// extern int go_chan_poll_wrap(lua_State *ctx);
+// extern int go_chan_wait_wrap(lua_State *ctx);
+// extern int go_chan_close_wrap(lua_State *ctx);
+// extern int go_obj_len_wrap(lua_State *ctx);
+// extern int go_obj_free_wrap(lua_State *ctx);
+import "C"
+import (
+	"context"
+	"reflect"
+	"unsafe"
+	"fmt"
+)
+
+var (
+	goChanMeta = "goChanMeta\x00"
+	drivenThreadsName = "luago.driven\x00" // the set of threads running in LuaThread.Resume()
+)
+
+// Go channels are exposed to Lua as userdata with methods `send`, `recv`, `tryRecv`,
+// `trySend` and `close`, and `chan.select{...}` selects from a list of cases:
+//
+//   local i, v, ok = chan.select{ {ch1, "recv"}, {ch2, "send", v}, {"default"} }
+//
+// where i is the index of the case selected, v and ok are the results of a receive.
+//
+// When an operation would block in a coroutine driven by LuaThread.Resume(), the
+// coroutine yields a selector of the cases, and Resume() waits for them without
+// locking the context. Elsewhere, coroutines wrapped by Lua included, the operation
+// blocks with the context locked until it is done or the Go context of WithContext()
+// is done.
+const chanModule = `
+local prim = ...
+local driven, running, yield = prim.driven, coroutine.running, coroutine.yield
+
+chan = {}
+
+function chan.select(cases)
+	local w = prim.compile(cases)
+	if not driven[running()] then
+		return prim.wait(w)
+	end
+	local i, v, ok = prim.poll(w)
+	while not i do
+		i, v, ok = yield(w)
+		if not i then
+			i, v, ok = prim.poll(w)
+		end
+	end
+	return i, v, ok
+end
+
+local methods = {}
+
+function methods.recv(ch)
+	local _, v, ok = chan.select{{ch, "recv"}}
+	return v, ok
+end
+
+function methods.send(ch, v)
+	chan.select{{ch, "send", v}}
+end
+
+-- ok is true if a value is received, false if ch is closed, nil if no value is ready.
+function methods.tryRecv(ch)
+	local i, v, ok = chan.select{{ch, "recv"}, {"default"}}
+	if i == 1 then
+		return v, ok
+	end
+	return nil, nil
+end
+
+function methods.trySend(ch, v)
+	return chan.select{{ch, "send", v}, {"default"}} == 1
+end
+
+methods.close = prim.close
+
+return methods
+`
+
+// chanSelect is the compiled cases of chan.select{}
+type chanSelect struct {
+	cases []reflect.SelectCase
+	hasDefault bool
+}
+
+func registerChanModule(ctx *C.lua_State) {
+	var name *C.char
+
+	registerMetatable(ctx, goChanMeta, &metaMethod{
+		name: __len, method: (C.lua_CFunction)(C.go_obj_len_wrap),
+	}, &metaMethod{
+		name: __gc, method: (C.lua_CFunction)(C.go_obj_free_wrap),
+	})
+	getStrPtr(&goChanMeta, &name)
+	C.lua_getfield(ctx, C.LUA_REGISTRYINDEX, name) // [ metatable ]
+
+	cstr := C.CString(chanModule)
+	defer C.free(unsafe.Pointer(cstr))
+	if C.luaL_loadstring(ctx, cstr) != 0 {
+		C.popN(ctx, 2) // [ ]
+		return
+	}
+	// [ metatable chunk ]
+	C.lua_createtable(ctx, 0, 5) // [ metatable chunk prim ]
+	getStrPtr(&drivenThreadsName, &name)
+	C.lua_createtable(ctx, 0, 0) // [ metatable chunk prim driven ]
+	C.lua_pushvalue(ctx, -1) // [ metatable chunk prim driven driven ]
+	C.lua_setfield(ctx, C.LUA_REGISTRYINDEX, name) // [ metatable chunk prim driven ]
+	pushString(ctx, "driven") // [ metatable chunk prim driven "driven" ]
+	C.lua_rotate(ctx, -2, 1) // [ metatable chunk prim "driven" driven ]
+	C.lua_rawset(ctx, -3) // [ metatable chunk prim ] with prim.driven = driven
+	for fnName, fn := range map[string]C.lua_CFunction{
+		"compile": (C.lua_CFunction)(C.go_chan_compile_wrap),
+		"poll": (C.lua_CFunction)(C.go_chan_poll_wrap),
+		"wait": (C.lua_CFunction)(C.go_chan_wait_wrap),
+		"close": (C.lua_CFunction)(C.go_chan_close_wrap),
+	} {
+		pushString(ctx, fnName) // [ metatable chunk prim name ]
+		C.lua_pushcclosure(ctx, fn, 0) // [ metatable chunk prim name fn ]
+		C.lua_rawset(ctx, -3) // [ metatable chunk prim ]
+	}
+	if C.lua_pcallk(ctx, 1, 1, 0, 0, nil) != 0 {
+		C.popN(ctx, 2) // [ ]
+		return
+	}
+	// [ metatable methods ]
+	getStrPtr(&__index, &name)
+	C.lua_setfield(ctx, -2, name) // [ metatable ] with metatable.__index = methods
+	C.popN(ctx, 1) // [ ]
+}
+
+func getChan(ctx *C.lua_State, idx C.int) (ch reflect.Value, ok bool) {
+	if C.lua_type(ctx, idx) != C.LUA_TUSERDATA {
+		return
+	}
+	v, o := getTargetValue(ctx, idx)
+	if !o || v == nil {
+		return
+	}
+	if ch = reflect.ValueOf(v); ch.Kind() == reflect.Chan {
+		ok = true
+	}
+	return
+}
+
+//export go_chan_compile
+func go_chan_compile(ctx *C.lua_State) C.int {
+	// [ 1 ] cases
+	if C.lua_type(ctx, 1) != C.LUA_TTABLE {
+		return luaError(ctx, "table of cases expected")
+	}
+	sel := &chanSelect{}
+	n := int(C.lua_rawlen(ctx, 1))
+	for i:=1; i<=n; i++ {
+		if err := sel.addCase(ctx, i); err != nil {
+			return luaError(ctx, fmt.Sprintf("case #%d: %v", i, err))
+		}
+	}
+	pushValueWithMetatable(ctx, sel, goObjMeta)
+	return 1
+}
+
+func (sel *chanSelect) addCase(ctx *C.lua_State, i int) (err error) {
+	C.lua_rawgeti(ctx, 1, C.lua_Integer(i)) // [ cases c ]
+	defer C.popN(ctx, 1) // [ cases ]
+	if C.lua_type(ctx, -1) != C.LUA_TTABLE {
+		return fmt.Errorf("table expected")
+	}
+
+	C.lua_rawgeti(ctx, -1, 1) // [ cases c c[1] ]
+	if C.lua_type(ctx, -1) == C.LUA_TSTRING {
+		C.popN(ctx, 1) // [ cases c ]
+		if sel.hasDefault {
+			return fmt.Errorf("more than one default case")
+		}
+		sel.hasDefault = true
+		sel.cases = append(sel.cases, reflect.SelectCase{Dir: reflect.SelectDefault})
+		return
+	}
+	ch, ok := getChan(ctx, -1)
+	C.popN(ctx, 1) // [ cases c ]
+	if !ok {
+		return fmt.Errorf("go channel expected")
+	}
+
+	C.lua_rawgeti(ctx, -1, 2) // [ cases c c[2] ]
+	op := C.GoString(C.lua_tolstring(ctx, -1, (*C.ulong)(unsafe.Pointer(nil))))
+	C.popN(ctx, 1) // [ cases c ]
+
+	chType := ch.Type()
+	switch op {
+	case "recv":
+		if chType.ChanDir() & reflect.RecvDir == 0 {
+			return fmt.Errorf("receive from send-only channel")
+		}
+		sel.cases = append(sel.cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: ch})
+	case "send":
+		if chType.ChanDir() & reflect.SendDir == 0 {
+			return fmt.Errorf("send to receive-only channel")
+		}
+		C.lua_rawgeti(ctx, -1, 3) // [ cases c c[3] ]
+		v, e := makeGoArg(ctx, chType.Elem())
+		C.popN(ctx, 1) // [ cases c ]
+		if e != nil {
+			return e
+		}
+		sel.cases = append(sel.cases, reflect.SelectCase{Dir: reflect.SelectSend, Chan: ch, Send: v})
+	default:
+		return fmt.Errorf(`"recv" or "send" expected`)
+	}
+	return
+}
+
+func getChanSelect(ctx *C.lua_State) (sel *chanSelect, ok bool) {
+	v, o := getTargetValue(ctx, 1)
+	if !o {
+		return
+	}
+	sel, ok = v.(*chanSelect)
+	return
+}
+
+// poll selects without blocking, the index of the case selected is returned.
+// -1 is returned if no case is ready.
+func (sel *chanSelect) poll() (chosen int, recv reflect.Value, ok bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	if sel.hasDefault {
+		chosen, recv, ok = reflect.Select(sel.cases)
+		return
+	}
+	cases := append(sel.cases[:len(sel.cases):len(sel.cases)], reflect.SelectCase{Dir: reflect.SelectDefault})
+	if chosen, recv, ok = reflect.Select(cases); chosen == len(sel.cases) {
+		chosen = -1
+	}
+	return
+}
+
+// wait selects until a case is ready, or goCtx is done.
+func (sel *chanSelect) wait(goCtx context.Context) (chosen int, recv reflect.Value, ok bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	cases := sel.cases
+	if done := goCtx.Done(); done != nil {
+		cases = append(sel.cases[:len(sel.cases):len(sel.cases)], reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)})
+	}
+	if chosen, recv, ok = reflect.Select(cases); chosen == len(sel.cases) {
+		err = goCtx.Err()
+	}
+	return
+}
+
+func (sel *chanSelect) pushResult(ctx *C.lua_State, chosen int, recv reflect.Value, ok bool) C.int {
+	C.lua_pushinteger(ctx, C.lua_Integer(chosen + 1)) // Lua is 1-based
+	if sel.cases[chosen].Dir != reflect.SelectRecv {
+		return 1
+	}
+	if ok {
+		pushLuaMetaValue(ctx, recv.Interface())
+		C.lua_pushboolean(ctx, 1)
+	} else {
+		C.lua_pushnil(ctx)
+		C.lua_pushboolean(ctx, 0)
+	}
+	return 3
+}
+
+//export go_chan_poll
+func go_chan_poll(ctx *C.lua_State) C.int {
+	// [ 1 ] selector
+	sel, ok := getChanSelect(ctx)
+	if !ok {
+		return luaError(ctx, "channel selector expected")
+	}
+	chosen, recv, ok, err := sel.poll()
+	if err != nil {
+		return luaError(ctx, err.Error())
+	}
+	if chosen < 0 {
+		return 0
+	}
+	return sel.pushResult(ctx, chosen, recv, ok)
+}
+
+//export go_chan_wait
+func go_chan_wait(ctx *C.lua_State) C.int {
+	// [ 1 ] selector
+	sel, ok := getChanSelect(ctx)
+	if !ok {
+		return luaError(ctx, "channel selector expected")
+	}
+	chosen, recv, ok, err := sel.wait(getGoContext(ctx))
+	if err != nil {
+		return luaError(ctx, err.Error())
+	}
+	return sel.pushResult(ctx, chosen, recv, ok)
+}
+
+//export go_chan_close
+func go_chan_close(ctx *C.lua_State) (n C.int) {
+	// [ 1 ] channel
+	ch, ok := getChan(ctx, 1)
+	if !ok {
+		return luaError(ctx, "go channel expected")
+	}
+	if ch.Type().ChanDir() & reflect.SendDir == 0 {
+		return luaError(ctx, "close of receive-only channel")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			n = luaError(ctx, fmt.Sprintf("%v", r))
+		}
+	}()
+	ch.Close()
+	return 0
+}
@@ -0,0 +1,124 @@
+package lua
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestChanOps(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, out := make(chan int, 2), make(chan string, 1)
+	in <- 1
+	in <- 2
+	err = ctx.LoadScript(`
+		assert(#inch == 2)
+		local v, ok = inch:recv()
+		assert(v == 1 and ok)
+		assert(chan.select{{outch, "send", "x"}} == 1)
+		assert(not outch:trySend("y"))
+		assert(inch:tryRecv() == 2)
+		inch:close()
+	`, map[string]interface{}{"inch": in, "outch": out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-out; !ok {
+		t.Fatal("value expected")
+	}
+
+	ch := make(chan int)
+	err = ctx.LoadScript(`
+		local v, ok = ch:tryRecv()
+		assert(v == nil and ok == nil)
+		assert(not ch:trySend(1))
+		assert(chan.select{{ch, "recv"}, {"default"}} == 2)
+	`, map[string]interface{}{"ch": ch})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChanInCoroutine(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan int, 3)
+	for i := 1; i <= 3; i++ {
+		ch <- i
+	}
+	close(ch)
+	// a generator receiving from a channel yields its own values only
+	err = ctx.LoadScript(`
+		local gen = coroutine.wrap(function()
+			while true do
+				local v, ok = ch:recv()
+				if not ok then
+					return
+				end
+				coroutine.yield(v * 10)
+			end
+		end)
+		local sum = 0
+		for v in gen do
+			assert(type(v) == "number")
+			sum = sum + v
+		end
+		assert(sum == 60)
+	`, map[string]interface{}{"ch": ch})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChanRecvCanceled(t *testing.T) {
+	goCtx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	ctx, err := NewContext(WithContext(goCtx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// blocking out of a coroutine ends once the Go context is done
+	err = ctx.LoadScript(`ch:recv()`, map[string]interface{}{"ch": make(chan int)})
+	if err == nil || !errors.Is(goCtx.Err(), context.DeadlineExceeded) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestChanInThread(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan int)
+	err = ctx.LoadScript(`
+		function main()
+			local v = ch:recv()
+			return v * 2
+		end
+	`, map[string]interface{}{"ch": ch})
+	if err != nil {
+		t.Fatal(err)
+	}
+	th, err := ctx.NewThread("main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer th.Close()
+
+	go func() {
+		// the context is not locked while the coroutine waits
+		if _, err := ctx.GetGlobal("main"); err == nil {
+			ch <- 21
+		}
+	}()
+	res, err := th.Resume()
+	if err != nil || len(res) != 1 || res[0] != 42.0 {
+		t.Fatalf("unexpected result: %v %v", res, err)
+	}
+}
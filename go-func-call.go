@@ -0,0 +1,158 @@
+package lua
+
+// #include "lua.h"
+// static void popN(lua_State *L, int n);
+import "C"
+import (
+	elutils "github.com/rosbit/go-embedding-utils"
+	"reflect"
+	"strings"
+	"fmt"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// callGoFunc calls a Go function with the Lua args from stack index argIdx to the top.
+// The result is nil for no result, a scalar for one result, or []interface{} for
+// more results. An error returned as the last result of the Go function is returned
+// as err.
+func callGoFunc(ctx *C.lua_State, fnVal reflect.Value, argIdx int) (res interface{}, err error) {
+	fnType := fnVal.Type()
+	argc := int(C.lua_gettop(ctx)) - argIdx + 1
+	if argc < 0 {
+		argc = 0
+	}
+
+	variadic := fnType.IsVariadic()
+	lastNumIn := fnType.NumIn() - 1
+	if variadic {
+		if argc < lastNumIn {
+			err = fmt.Errorf("at least %d args expected", lastNumIn)
+			return
+		}
+	} else if argc != fnType.NumIn() {
+		err = fmt.Errorf("%d args expected", fnType.NumIn())
+		return
+	}
+
+	// make golang func args
+	goArgs := make([]reflect.Value, argc)
+	for i:=0; i<argc; i++ {
+		var argType reflect.Type
+		if i < lastNumIn || !variadic {
+			argType = fnType.In(i)
+		} else {
+			argType = fnType.In(lastNumIn).Elem()
+		}
+
+		C.lua_pushvalue(ctx, C.int(argIdx + i)) // [ ... argI ]
+		goArgs[i], err = makeGoArg(ctx, argType)
+		C.popN(ctx, 1) // [ ... ]
+		if err != nil {
+			err = fmt.Errorf("bad argument #%d: %v", i+1, err)
+			return
+		}
+	}
+
+	// call golang func
+	var out []reflect.Value
+	if out, err = callGoFuncSafely(ctx, fnVal, goArgs); err != nil {
+		return
+	}
+
+	return goFuncResults(fnType, out)
+}
+
+// goFuncResults converts the results of a Go function to nil for no result, a scalar
+// for one result, or []interface{} for more results. An error returned as the last
+// result is returned as err.
+func goFuncResults(fnType reflect.Type, out []reflect.Value) (res interface{}, err error) {
+	retc := len(out)
+	if retc > 0 && fnType.Out(retc-1) == errorType {
+		if e := out[retc-1].Interface(); e != nil {
+			err = e.(error)
+			return
+		}
+		retc -= 1
+	}
+	switch retc {
+	case 0:
+	case 1:
+		res = out[0].Interface()
+	default:
+		retV := make([]interface{}, retc)
+		for i:=0; i<retc; i++ {
+			retV[i] = out[i].Interface()
+		}
+		res = retV
+	}
+	return
+}
+
+// a panic must not unwind the C frames of Lua, it is returned as an error.
+// The Go function may call back into Lua through the handles, see ctxLock.
+func callGoFuncSafely(ctx *C.lua_State, fnVal reflect.Value, goArgs []reflect.Value) (out []reflect.Value, err error) {
+	mu := getCtxEnv(ctx).mu
+	mu.enterGo()
+	defer func() {
+		mu.exitGo()
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic in go function: %v", r)
+		}
+	}()
+	out = fnVal.Call(goArgs)
+	return
+}
+
+// makeGoArg converts the value at the top of the stack to a value of type argType.
+func makeGoArg(ctx *C.lua_State, argType reflect.Type) (arg reflect.Value, err error) {
+	goVal, e := fromLuaValueAs(ctx, argType)
+	if e != nil {
+		err = e
+		return
+	}
+	if s, ok := goVal.(string); ok {
+		goVal = strings.Clone(s)
+	}
+
+	if isBytesType(argType) {
+		// the original []byte of a Bytes is passed, so Lua can fill it in place
+		var b []byte
+		switch v := goVal.(type) {
+		case nil:
+		case Bytes:
+			b = v
+		case string:
+			b = []byte(v)
+		default:
+			err = fmt.Errorf("cannot convert %T to %s", goVal, argType)
+			return
+		}
+		arg = reflect.ValueOf(b).Convert(argType)
+		return
+	}
+
+	switch argType.Kind() {
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer, reflect.Uintptr, reflect.Complex64, reflect.Complex128:
+		// values of these kinds come from Go only, and elutils.MakeValue() panics for most of them
+		arg = reflect.New(argType).Elem()
+		err = elutils.SetValue(arg, goVal)
+		return
+	}
+
+	// elutils panics for the values nesting the kinds above, such as *chan int
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("cannot convert %T to %s", goVal, argType)
+		}
+	}()
+	arg = elutils.MakeValue(argType)
+	if err = elutils.SetValue(arg, goVal); err != nil {
+		return
+	}
+	return
+}
+
+func isBytesType(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8
+}
@@ -0,0 +1,62 @@
+package lua
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCallGoFunc(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan int, 1)
+	err = ctx.LoadScript(`
+		assert(add(1, 2) == 3)
+		assert(join("-", "a", "b") == "a-b")
+		assert(apply(function(x) return x * 2 end, 21) == 42)
+		local ok, err = pcall(fail)
+		assert(not ok and err:find("failed"))
+		put(ch, 7)
+	`, map[string]interface{}{
+		"add": func(a, b int) int { return a + b },
+		"join": func(sep string, s ...string) string { return strings.Join(s, sep) },
+		"apply": func(f func(int) int, x int) int { return f(x) },
+		"fail": func() error { return errors.New("failed") },
+		"put": func(c chan int, v int) { c <- v },
+		"ch": ch,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := <-ch; v != 7 {
+		t.Fatalf("unexpected value %d", v)
+	}
+}
+
+func TestCallGoFuncBadArgs(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]interface{}{
+		"add": func(a, b int) int { return a + b },
+		"put": func(c chan int) {},
+		"putp": func(c *chan int) {},
+		"call": func(f func()) {},
+	}
+	for _, script := range []string{
+		`add(1)`,
+		`add(1, true)`,
+		`put(1)`,
+		`put("x")`,
+		`putp(1)`,
+		`call(1)`,
+	} {
+		err = ctx.LoadScript(script, env)
+		if err == nil || !strings.Contains(err.Error(), "bad argument") && !strings.Contains(err.Error(), "args expected") {
+			t.Fatalf("%s: unexpected error %v", script, err)
+		}
+	}
+}
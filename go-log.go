@@ -0,0 +1,139 @@
+package lua
+
+// #include "lua.h"
+// #include "lauxlib.h"
+// static void popN(lua_State *L, int n);
+// extern int go_log_wrap(lua_State *ctx);
+// extern int protectedToString(lua_State *L, int idx);
+import "C"
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strings"
+	"fmt"
+)
+
+// WithLogger sets the logger of the Lua module `log`, slog.Default() is used if not set.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// registerLogModule registers the global `log` with functions `debug`, `info`, `warn`
+// and `error`, which are called as `log.info(msg, {key=value, ...})`. The chunk name and
+// the line of the caller are added as attributes `chunk` and `line`.
+func registerLogModule(ctx *C.lua_State) {
+	C.lua_createtable(ctx, 0, 4) // [ log ]
+	for name, level := range map[string]slog.Level{
+		"debug": slog.LevelDebug,
+		"info": slog.LevelInfo,
+		"warn": slog.LevelWarn,
+		"error": slog.LevelError,
+	} {
+		pushString(ctx, name) // [ log name ]
+		C.lua_pushinteger(ctx, C.lua_Integer(level)) // [ log name level ]
+		C.lua_pushcclosure(ctx, (C.lua_CFunction)(C.go_log_wrap), 1) // [ log name fn ] with level as upvalue
+		C.lua_rawset(ctx, -3) // [ log ]
+	}
+	name := "log\x00"
+	var cName *C.char
+	getStrPtr(&name, &cName)
+	C.lua_setglobal(ctx, cName) // [ ]
+}
+
+func getLogger(ctx *C.lua_State) *slog.Logger {
+	if env := getCtxEnv(ctx); env != nil && env.opts.logger != nil {
+		return env.opts.logger
+	}
+	return slog.Default()
+}
+
+//export go_log
+func go_log(ctx *C.lua_State) C.int {
+	// [ 1 ] msg
+	// [ 2 ] fields, optional
+	level := slog.Level(C.lua_tointegerx(ctx, C.LUA_REGISTRYINDEX - 1, nil)) // upvalue 1
+	logger := getLogger(ctx)
+	if !logger.Enabled(context.Background(), level) {
+		return 0
+	}
+
+	if C.protectedToString(ctx, 1) != C.LUA_OK {
+		// [ msg fields err ], raised again by the C wrapper
+		return -1
+	}
+	// [ msg fields msg-str ]
+	var length C.size_t
+	s := C.lua_tolstring(ctx, -1, &length)
+	msg := C.GoStringN(s, C.int(length))
+	C.popN(ctx, 1) // [ msg fields ]
+
+	attrs := callerAttrs(ctx)
+	if C.lua_type(ctx, 2) == C.LUA_TTABLE {
+		fields, err := logFields(ctx)
+		if err != nil {
+			return luaError(ctx, fmt.Sprintf("bad argument #2 to '%s' (%v)", strings.ToLower(level.String()), err))
+		}
+		attrs = append(attrs, fields...)
+	}
+	logger.LogAttrs(context.Background(), level, msg, attrs...)
+	return 0
+}
+
+// the chunk name and the current line of the Lua function calling log
+func callerAttrs(ctx *C.lua_State) []slog.Attr {
+	var ar C.lua_Debug
+	if C.lua_getstack(ctx, 1, &ar) == 0 {
+		return nil
+	}
+	what := "Sl\x00"
+	var cWhat *C.char
+	getStrPtr(&what, &cWhat)
+	if C.lua_getinfo(ctx, cWhat, &ar) == 0 {
+		return nil
+	}
+	return []slog.Attr{
+		slog.String("chunk", C.GoString(&ar.short_src[0])),
+		slog.Int("line", int(ar.currentline)),
+	}
+}
+
+// logFields converts the fields table at index 2 to attributes sorted by keys.
+func logFields(ctx *C.lua_State) (attrs []slog.Attr, err error) {
+	C.lua_pushvalue(ctx, 2) // [ ... fields ]
+	v, e := fromLuaValue(ctx)
+	C.popN(ctx, 1) // [ ... ]
+	if e != nil {
+		err = e
+		return
+	}
+	fields := make(map[string]interface{})
+	switch m := v.(type) {
+	case nil:
+	case map[string]interface{}:
+		fields = m
+	case map[interface{}]interface{}:
+		for k, val := range m {
+			fields[fmt.Sprintf("%v", k)] = val
+		}
+	default:
+		err = fmt.Errorf("table with string keys expected")
+		return
+	}
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		val := fields[k]
+		if s, ok := val.(string); ok {
+			val = strings.Clone(s)
+		}
+		attrs = append(attrs, slog.Any(strings.Clone(k), val))
+	}
+	return
+}
@@ -0,0 +1,48 @@
+package lua
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLog(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger := slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	ctx, err := NewContext(WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.LoadScript(`
+		log.debug("hidden")
+		log.info("hello", {user="bob", n=3})
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := out.String()
+	if strings.Contains(s, "hidden") {
+		t.Fatalf("debug message logged: %q", s)
+	}
+	for _, want := range []string{"level=INFO", "msg=hello", "user=bob", "n=3", "line=3"} {
+		if !strings.Contains(s, want) {
+			t.Fatalf("%q not found in %q", want, s)
+		}
+	}
+}
+
+func TestLogTostringError(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	ctx, err := NewContext(WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.LoadScript(`
+		local ok, err = pcall(log.info, setmetatable({}, {__tostring=function() error("x") end}))
+		assert(not ok and err:find("x"))
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
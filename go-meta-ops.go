@@ -0,0 +1,327 @@
+package lua
+
+// #include "lua.h"
+// static void popN(lua_State *L, int n);
+import "C"
+import (
+	"reflect"
+	"strings"
+	"unsafe"
+	"fmt"
+)
+
+// Comparer is implemented by Go types which can be compared with `<`, `<=`, `>`
+// and `>=` in Lua.
+type Comparer interface {
+	// Compare returns a negative number if the receiver is less than other, 0 if
+	// they are equal, or a positive number if the receiver is greater than other.
+	Compare(other interface{}) (int, error)
+}
+
+// Operator is implemented by Go types supporting arithmetic operators in Lua.
+type Operator interface {
+	// Operate returns the result of `a op b`, in which a or b is the receiver. op is
+	// one of "add", "sub", "mul", "div", "mod", "pow", "idiv" and "unm", b is nil for "unm".
+	Operate(op string, a, b interface{}) (interface{}, error)
+}
+
+// getOperand converts the operand of a metamethod to a Go value.
+// isGo is true if it is a Go value pushed to Lua.
+func getOperand(ctx *C.lua_State, idx C.int) (v interface{}, isGo bool, err error) {
+	if C.lua_type(ctx, idx) == C.LUA_TUSERDATA {
+		if v, isGo = getTargetValue(ctx, idx); isGo {
+			return
+		}
+	}
+	C.lua_pushvalue(ctx, idx) // [ ... operand ]
+	v, err = fromLuaValue(ctx)
+	C.popN(ctx, 1) // [ ... ]
+	if s, ok := v.(string); ok {
+		v = strings.Clone(s)
+	}
+	return
+}
+
+// callMethodSafely calls fn, which calls a method of a Go value for a metamethod.
+// like callGoFuncSafely(), a panic is returned as an error.
+func callMethodSafely(ctx *C.lua_State, fn func() error) (err error) {
+	mu := getCtxEnv(ctx).mu
+	mu.enterGo()
+	defer func() {
+		mu.exitGo()
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic in go method: %v", r)
+		}
+	}()
+	return fn()
+}
+
+func goToString(ctx *C.lua_State, v interface{}) (s string, err error) {
+	err = callMethodSafely(ctx, func() error {
+		switch vv := v.(type) {
+		case fmt.Stringer:
+			s = vv.String()
+		case error:
+			s = vv.Error()
+		default:
+			s = fmt.Sprintf("%v", v)
+		}
+		return nil
+	})
+	return
+}
+
+//export go_obj_tostring
+func go_obj_tostring(ctx *C.lua_State) C.int {
+	// [ 1 ] go_meta_proxy
+	v, _, err := getOperand(ctx, 1)
+	if err != nil {
+		return luaError(ctx, err.Error())
+	}
+	s, err := goToString(ctx, v)
+	if err != nil {
+		return luaError(ctx, err.Error())
+	}
+	pushString(ctx, s)
+	return 1
+}
+
+//export go_obj_concat
+func go_obj_concat(ctx *C.lua_State) C.int {
+	// [ 1 ] a
+	// [ 2 ] b
+	var s [2]string
+	for i := range s {
+		idx := C.int(i + 1)
+		switch C.lua_type(ctx, idx) {
+		case C.LUA_TSTRING, C.LUA_TNUMBER:
+			C.lua_pushvalue(ctx, idx) // [ a b operand ]
+			s[i] = C.GoString(C.lua_tolstring(ctx, -1, (*C.ulong)(unsafe.Pointer(nil))))
+			C.popN(ctx, 1) // [ a b ]
+		default:
+			v, isGo, err := getOperand(ctx, idx)
+			if err != nil {
+				return luaError(ctx, err.Error())
+			}
+			if !isGo {
+				return luaError(ctx, fmt.Sprintf("attempt to concatenate a %T value", v))
+			}
+			if s[i], err = goToString(ctx, v); err != nil {
+				return luaError(ctx, err.Error())
+			}
+		}
+	}
+	pushString(ctx, s[0] + s[1])
+	return 1
+}
+
+//export go_obj_eq
+func go_obj_eq(ctx *C.lua_State) C.int {
+	// [ 1 ] a
+	// [ 2 ] b
+	a, _, err := getOperand(ctx, 1)
+	if err != nil {
+		return luaError(ctx, err.Error())
+	}
+	b, _, err := getOperand(ctx, 2)
+	if err != nil {
+		return luaError(ctx, err.Error())
+	}
+	if goValueEqual(a, b) {
+		C.lua_pushboolean(ctx, 1)
+	} else {
+		C.lua_pushboolean(ctx, 0)
+	}
+	return 1
+}
+
+// goValueEqual compares Go values with ==, values of slices, maps and funcs are
+// equal if they refer to the same data.
+func goValueEqual(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if va.Type() != vb.Type() {
+		return false
+	}
+	if va.Type().Comparable() {
+		// == panics if an interface in the values holds a slice, a map or a func
+		if va.Comparable() && vb.Comparable() {
+			return a == b
+		}
+		return false
+	}
+	switch va.Kind() {
+	case reflect.Slice:
+		return va.Pointer() == vb.Pointer() && va.Len() == vb.Len()
+	case reflect.Map, reflect.Func:
+		return va.Pointer() == vb.Pointer()
+	default:
+		return false
+	}
+}
+
+//export go_obj_lt
+func go_obj_lt(ctx *C.lua_State) C.int {
+	return goObjCompare(ctx, func(r int) bool { return r < 0 })
+}
+
+//export go_obj_le
+func go_obj_le(ctx *C.lua_State) C.int {
+	return goObjCompare(ctx, func(r int) bool { return r <= 0 })
+}
+
+func goObjCompare(ctx *C.lua_State, test func(int) bool) C.int {
+	// [ 1 ] a
+	// [ 2 ] b
+	a, _, err := getOperand(ctx, 1)
+	if err != nil {
+		return luaError(ctx, err.Error())
+	}
+	b, _, err := getOperand(ctx, 2)
+	if err != nil {
+		return luaError(ctx, err.Error())
+	}
+	r, err := goValueCompare(ctx, a, b)
+	if err != nil {
+		return luaError(ctx, err.Error())
+	}
+	if test(r) {
+		C.lua_pushboolean(ctx, 1)
+	} else {
+		C.lua_pushboolean(ctx, 0)
+	}
+	return 1
+}
+
+func goValueCompare(ctx *C.lua_State, a, b interface{}) (r int, err error) {
+	if c, ok := a.(Comparer); ok {
+		err = callMethodSafely(ctx, func() (e error) {
+			r, e = c.Compare(b)
+			return
+		})
+		return
+	}
+	if c, ok := b.(Comparer); ok {
+		err = callMethodSafely(ctx, func() (e error) {
+			r, e = c.Compare(a)
+			return
+		})
+		return -r, err
+	}
+
+	// values of ordered kinds
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if a != nil && b != nil {
+		switch {
+		case isNumberKind(va.Kind()) && isNumberKind(vb.Kind()):
+			fa, fb := toFloat(va), toFloat(vb)
+			switch {
+			case fa < fb:
+				return -1, nil
+			case fa > fb:
+				return 1, nil
+			default:
+				return 0, nil
+			}
+		case va.Kind() == reflect.String && vb.Kind() == reflect.String:
+			return strings.Compare(va.String(), vb.String()), nil
+		}
+	}
+	return 0, fmt.Errorf("attempt to compare %T with %T", a, b)
+}
+
+func isNumberKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	default:
+		return false
+	}
+}
+
+func toFloat(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint())
+	default:
+		return v.Float()
+	}
+}
+
+func goObjArith(ctx *C.lua_State, op string) C.int {
+	// [ 1 ] a
+	// [ 2 ] b, the same as a for unary minus
+	a, _, err := getOperand(ctx, 1)
+	if err != nil {
+		return luaError(ctx, err.Error())
+	}
+	var b interface{}
+	if op != "unm" {
+		if b, _, err = getOperand(ctx, 2); err != nil {
+			return luaError(ctx, err.Error())
+		}
+	}
+
+	o, ok := a.(Operator)
+	if !ok {
+		if o, ok = b.(Operator); !ok {
+			return luaError(ctx, fmt.Sprintf("attempt to perform arithmetic %s on %T", op, a))
+		}
+	}
+	var res interface{}
+	err = callMethodSafely(ctx, func() (e error) {
+		res, e = o.Operate(op, a, b)
+		return
+	})
+	if err != nil {
+		return luaError(ctx, err.Error())
+	}
+	pushLuaMetaValue(ctx, res)
+	return 1
+}
+
+//export go_obj_add
+func go_obj_add(ctx *C.lua_State) C.int {
+	return goObjArith(ctx, "add")
+}
+
+//export go_obj_sub
+func go_obj_sub(ctx *C.lua_State) C.int {
+	return goObjArith(ctx, "sub")
+}
+
+//export go_obj_mul
+func go_obj_mul(ctx *C.lua_State) C.int {
+	return goObjArith(ctx, "mul")
+}
+
+//export go_obj_div
+func go_obj_div(ctx *C.lua_State) C.int {
+	return goObjArith(ctx, "div")
+}
+
+//export go_obj_mod
+func go_obj_mod(ctx *C.lua_State) C.int {
+	return goObjArith(ctx, "mod")
+}
+
+//export go_obj_pow
+func go_obj_pow(ctx *C.lua_State) C.int {
+	return goObjArith(ctx, "pow")
+}
+
+//export go_obj_idiv
+func go_obj_idiv(ctx *C.lua_State) C.int {
+	return goObjArith(ctx, "idiv")
+}
+
+//export go_obj_unm
+func go_obj_unm(ctx *C.lua_State) C.int {
+	return goObjArith(ctx, "unm")
+}
@@ -0,0 +1,116 @@
+package lua
+
+import (
+	"testing"
+	"fmt"
+)
+
+type testVec struct {
+	X, Y float64
+}
+
+func (v *testVec) String() string {
+	return fmt.Sprintf("(%g, %g)", v.X, v.Y)
+}
+
+func (v *testVec) Compare(other interface{}) (int, error) {
+	o, ok := other.(*testVec)
+	if !ok {
+		return 0, fmt.Errorf("vec expected")
+	}
+	return int(v.X*v.X + v.Y*v.Y - o.X*o.X - o.Y*o.Y), nil
+}
+
+func (v *testVec) Operate(op string, a, b interface{}) (interface{}, error) {
+	switch op {
+	case "add":
+		x, y := a.(*testVec), b.(*testVec)
+		return &testVec{x.X + y.X, x.Y + y.Y}, nil
+	case "mul":
+		if f, ok := a.(float64); ok {
+			y := b.(*testVec)
+			return &testVec{f * y.X, f * y.Y}, nil
+		}
+	case "unm":
+		x := a.(*testVec)
+		return &testVec{-x.X, -x.Y}, nil
+	}
+	return nil, fmt.Errorf("operator %s not supported", op)
+}
+
+func TestOperators(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := &testVec{1, 2}, &testVec{3, 4}
+	err = ctx.LoadScript(`
+		assert(tostring(a) == "(1, 2)")
+		assert("v" .. a == "v(1, 2)")
+		assert(a == a2 and a ~= b)
+		assert(a < b and a <= b and b > a)
+		assert(tostring(a + b) == "(4, 6)")
+		assert(tostring(2 * a) == "(2, 4)")
+		assert(tostring(-a) == "(-1, -2)")
+		assert(not pcall(function() return a - b end))
+	`, map[string]interface{}{"a": a, "a2": a, "b": b})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+type testHolder struct {
+	V interface{}
+}
+
+func TestEqualNotComparable(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := []int{1}
+	err = ctx.LoadScript(`
+		assert(a ~= b)
+		assert(a == a)
+		assert(c == d)
+	`, map[string]interface{}{
+		"a": testHolder{V: s},
+		"b": testHolder{V: s},
+		"c": testHolder{V: 1},
+		"d": testHolder{V: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+type testBroken struct{}
+
+func (b *testBroken) String() string {
+	panic("broken String")
+}
+
+func (b *testBroken) Compare(other interface{}) (int, error) {
+	panic("broken Compare")
+}
+
+func TestMethodPanics(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a panic in a method called by a metamethod is a Lua error, which can be caught
+	err = ctx.LoadScript(`
+		local function fails(f, msg)
+			local ok, err = pcall(f)
+			assert(not ok and string.find(tostring(err), msg, 1, true), tostring(err))
+		end
+		fails(function() return tostring(b) end, "broken String")
+		fails(function() return "b: " .. b end, "broken String")
+		fails(function() return b < b end, "broken Compare")
+		fails(function() return v + 1 end, "panic in go method")
+	`, map[string]interface{}{"b": &testBroken{}, "v": &testVec{1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -4,27 +4,83 @@ package lua
 // #include "lualib.h"
 // #include "lauxlib.h"
 // static void popN(lua_State *L, int n);
-// extern int go_obj_get(lua_State *ctx);
-// extern int go_obj_set(lua_State *ctx);
-// extern int go_obj_len(lua_State *ctx);
-// extern int go_func_call(lua_State *ctx);
-// extern int go_obj_free(lua_State *ctx);
+// extern int go_obj_get_wrap(lua_State *ctx);
+// extern int go_obj_set_wrap(lua_State *ctx);
+// extern int go_obj_len_wrap(lua_State *ctx);
+// extern int go_func_call_wrap(lua_State *ctx);
+// extern int go_obj_free_wrap(lua_State *ctx);
+// extern int go_obj_tostring_wrap(lua_State *ctx);
+// extern int go_obj_concat_wrap(lua_State *ctx);
+// extern int go_obj_eq_wrap(lua_State *ctx);
+// extern int go_obj_lt_wrap(lua_State *ctx);
+// extern int go_obj_le_wrap(lua_State *ctx);
+// extern int go_obj_add_wrap(lua_State *ctx);
+// extern int go_obj_sub_wrap(lua_State *ctx);
+// extern int go_obj_mul_wrap(lua_State *ctx);
+// extern int go_obj_div_wrap(lua_State *ctx);
+// extern int go_obj_mod_wrap(lua_State *ctx);
+// extern int go_obj_pow_wrap(lua_State *ctx);
+// extern int go_obj_idiv_wrap(lua_State *ctx);
+// extern int go_obj_unm_wrap(lua_State *ctx);
 import "C"
 import (
 	elutils "github.com/rosbit/go-embedding-utils"
 	"reflect"
+	"time"
 	"unsafe"
 	"fmt"
-	"os"
 	"strings"
 )
 
 func pushLuaMetaValue(ctx *C.lua_State, v interface{}) {
+	pushGoValue(ctx, v, isReadOnlyContext(ctx))
+}
+
+// pushElemValue pushes a field or an element of the Go value of the proxy at index 1,
+// which is read-only if the proxy is.
+func pushElemValue(ctx *C.lua_State, v interface{}) {
+	pushGoValue(ctx, v, isReadOnlyProxy(ctx, 1) || isReadOnlyContext(ctx))
+}
+
+func pushGoValue(ctx *C.lua_State, v interface{}, readOnly bool) {
 	if v == nil {
 		C.lua_pushnil(ctx)
 		return
 	}
 
+	switch vv := v.(type) {
+	case *LuaTable:
+		vv.pushTo(ctx)
+		return
+	case *LuaFunction:
+		vv.pushTo(ctx)
+		return
+	case *LuaThread:
+		vv.pushTo(ctx)
+		return
+	case *AsyncFunc:
+		pushAsyncFunc(ctx, vv)
+		return
+	case Bytes:
+		if readOnly {
+			pushValueWithMetatable(ctx, vv, goBytesROMeta)
+		} else {
+			pushValueWithMetatable(ctx, vv, goBytesMeta)
+		}
+		return
+	case time.Time:
+		pushTime(ctx, vv)
+		return
+	case *readOnlyValue:
+		pushGoValue(ctx, vv.v, true)
+		return
+	}
+
+	objMeta := goObjMeta
+	if readOnly {
+		objMeta = goObjROMeta
+	}
+
 	vv := reflect.ValueOf(v)
 	switch vv.Kind() {
 	case reflect.Bool:
@@ -44,28 +100,34 @@ func pushLuaMetaValue(ctx *C.lua_State, v interface{}) {
 		C.lua_pushnumber(ctx, C.lua_Number(vv.Float()))
 		return
 	case reflect.String:
-		pushString(ctx, v.(string))
+		pushString(ctx, vv.String())
 		return
 	case reflect.Slice:
-		t := vv.Type()
-		if t.Elem().Kind() == reflect.Uint8 {
-			pushString(ctx, string(v.([]byte)))
+		if isBytesType(vv.Type()) {
+			pushString(ctx, string(vv.Bytes()))
 			return
 		}
 		fallthrough
 	case reflect.Array, reflect.Map, reflect.Struct, reflect.Interface:
-		pushValueWithMetatable(ctx, v, goObjMeta)
+		pushValueWithMetatable(ctx, v, objMeta)
 		return
 	case reflect.Ptr:
 		if vv.Elem().Kind() == reflect.Struct {
-			pushValueWithMetatable(ctx, v, goObjMeta)
+			pushValueWithMetatable(ctx, v, objMeta)
 			return
 		}
-		pushLuaMetaValue(ctx, vv.Elem().Interface())
+		pushGoValue(ctx, vv.Elem().Interface(), readOnly)
 		return
 	case reflect.Func:
 		pushValueWithMetatable(ctx, v, goFuncMeta)
 		return
+	case reflect.Chan:
+		if readOnly && vv.Type().ChanDir() == reflect.BothDir {
+			// a read-only channel can't be sent to or closed
+			v = vv.Convert(reflect.ChanOf(reflect.RecvDir, vv.Type().Elem())).Interface()
+		}
+		pushValueWithMetatable(ctx, v, goChanMeta)
+		return
 	default:
 		C.lua_pushnil(ctx)
 		return
@@ -109,7 +171,7 @@ func go_arr_get(ctx *C.lua_State, vv reflect.Value) C.int {
 		C.lua_pushnil(ctx)
 		return 1
 	}
-	pushLuaMetaValue(ctx, val.Interface())
+	pushElemValue(ctx, val.Interface())
 	return 1
 }
 
@@ -119,45 +181,106 @@ func go_arr_set(ctx *C.lua_State, vv reflect.Value) C.int {
 	// [3]: val
 	key, err := getArrayKey(ctx, vv)
 	if err != nil {
-		pushString(ctx, err.Error())
-		C.lua_error(ctx)
-		return 1
-	}
-	goVal, err := fromLuaValue(ctx)
-	if err != nil {
-		es := err.Error()
-		pushString(ctx, es)
-		C.lua_error(ctx)
-		return 1
+		return luaError(ctx, err.Error())
 	}
 
 	dest := vv.Index(key)
-	if _, ok := goVal.(string); ok {
-		goVal = fmt.Sprintf("%s", goVal) // deep copy
+	if hasReadOnlyFields(dest.Type()) {
+		return luaError(ctx, fmt.Sprintf("element %d has read-only fields", key+1))
 	}
-	if err = elutils.SetValue(dest, goVal); err != nil {
-		es := err.Error()
-		pushString(ctx, es)
-		C.lua_error(ctx)
-		return 1
+	if !dest.CanSet() {
+		return luaError(ctx, fmt.Sprintf("element %d cannot be set", key+1))
+	}
+	if err = decodeLuaValue(ctx, dest, fmt.Sprintf("[%d]", key+1)); err != nil {
+		return luaError(ctx, err.Error())
 	}
 	return 0
 }
 
+// getMapKey converts the Lua key to the key type of a Go map.
+func getMapKey(ctx *C.lua_State, keyType reflect.Type) (key reflect.Value, err error) {
+	// [1]: ...
+	// [2]: key
+	key = reflect.New(keyType).Elem()
+	switch keyType.Kind() {
+	case reflect.String:
+		if C.lua_isstring(ctx, 2) == 0 {
+			err = fmt.Errorf("string key expected")
+			return
+		}
+		key.SetString(C.GoString(C.lua_tolstring(ctx, 2, (*C.ulong)(unsafe.Pointer(nil)))))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := getIntegerKey(ctx)
+		if !ok || key.OverflowInt(i) {
+			err = fmt.Errorf("key of %s expected", keyType)
+			return
+		}
+		key.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i, ok := getIntegerKey(ctx)
+		if !ok || i < 0 || key.OverflowUint(uint64(i)) {
+			err = fmt.Errorf("key of %s expected", keyType)
+			return
+		}
+		key.SetUint(uint64(i))
+	case reflect.Float32, reflect.Float64:
+		if C.lua_type(ctx, 2) != C.LUA_TNUMBER {
+			err = fmt.Errorf("key of %s expected", keyType)
+			return
+		}
+		key.SetFloat(float64(C.lua_tonumberx(ctx, 2, (*C.int)(unsafe.Pointer(nil)))))
+	case reflect.Bool:
+		if C.lua_type(ctx, 2) != C.LUA_TBOOLEAN {
+			err = fmt.Errorf("key of %s expected", keyType)
+			return
+		}
+		key.SetBool(C.lua_toboolean(ctx, 2) != 0)
+	default:
+		C.lua_pushvalue(ctx, 2) // [ ... key ]
+		goKey, e := fromLuaValue(ctx)
+		C.popN(ctx, 1) // [ ... ]
+		if e != nil {
+			err = e
+			return
+		}
+		if s, ok := goKey.(string); ok {
+			goKey = strings.Clone(s)
+		}
+		if err = elutils.SetValue(key, goKey); err != nil {
+			return
+		}
+		if !key.Type().Comparable() || (key.Kind() == reflect.Interface && !key.IsNil() && !key.Elem().Type().Comparable()) {
+			err = fmt.Errorf("key of %s is not comparable", key.Type())
+		}
+	}
+	return
+}
+
+// integers and floats with integral values are accepted as integer keys
+func getIntegerKey(ctx *C.lua_State) (i int64, ok bool) {
+	if C.lua_type(ctx, 2) != C.LUA_TNUMBER {
+		return
+	}
+	var isNum C.int
+	i = int64(C.lua_tointegerx(ctx, 2, &isNum))
+	ok = isNum != 0
+	return
+}
+
 func go_map_get(ctx *C.lua_State, vv reflect.Value) C.int {
 	// [1]: ...
 	// [2]: key
-	if C.lua_isstring(ctx, 2) == 0 {
+	key, err := getMapKey(ctx, vv.Type().Key())
+	if err != nil {
 		C.lua_pushnil(ctx)
 		return 1
 	}
-	key := C.GoString(C.lua_tolstring(ctx, 2, (*C.ulong)(unsafe.Pointer(nil))))
-	val := vv.MapIndex(reflect.ValueOf(key))
+	val := vv.MapIndex(key)
 	if !val.IsValid() || !val.CanInterface() {
 		C.lua_pushnil(ctx)
 		return 1
 	}
-	pushLuaMetaValue(ctx, val.Interface())
+	pushElemValue(ctx, val.Interface())
 	return 1
 }
 
@@ -165,33 +288,21 @@ func go_map_set(ctx *C.lua_State, vv reflect.Value) C.int {
 	// [1]: ...
 	// [2]: key
 	// [3]: val
-	if C.lua_isstring(ctx, 2) == 0 {
-		pushString(ctx, "string expected")
-		C.lua_error(ctx)
-		return 1
-	}
-	key := C.GoString(C.lua_tolstring(ctx, 2, (*C.ulong)(unsafe.Pointer(nil))))
-	goVal, err := fromLuaValue(ctx)
+	mapT := vv.Type()
+	key, err := getMapKey(ctx, mapT.Key())
 	if err != nil {
-		pushString(ctx, err.Error())
-		C.lua_error(ctx)
-		return 1
+		return luaError(ctx, err.Error())
 	}
-
-	mapT := vv.Type()
 	elType := mapT.Elem()
-	dest := elutils.MakeValue(elType)
-	if _, ok := goVal.(string); ok {
-		goVal = fmt.Sprintf("%s", goVal) // deep copy
+	if hasReadOnlyFields(elType) {
+		return luaError(ctx, fmt.Sprintf("element %v has read-only fields", key.Interface()))
 	}
-	if err = elutils.SetValue(dest, goVal); err == nil {
-		vv.SetMapIndex(reflect.ValueOf(key), dest)
-		return 0
-	} else {
-		pushString(ctx, err.Error())
-		C.lua_error(ctx)
-		return 1
+	dest := reflect.New(elType).Elem()
+	if err = decodeLuaValue(ctx, dest, fmt.Sprintf("[%v]", key.Interface())); err != nil {
+		return luaError(ctx, err.Error())
 	}
+	vv.SetMapIndex(key, dest)
+	return 0
 }
 
 func go_struct_get(ctx *C.lua_State, structVar reflect.Value) C.int {
@@ -217,22 +328,27 @@ func go_struct_get(ctx *C.lua_State, structVar reflect.Value) C.int {
 		return 1
 	}
 	name := upperFirst(key)
-	fv := structE.FieldByName(name)
-	if !fv.IsValid() {
+	sf, fv, found := luaStructField(structE, key, false)
+	if found && !fv.IsValid() {
+		// promoted through a nil embedded pointer
+		C.lua_pushnil(ctx)
+		return 1
+	}
+	if !found {
 		fv = structE.MethodByName(name)
 		if !fv.IsValid() {
 			if structE == structVar {
-				C.lua_pushnil(ctx)
+				pushUnknownField(ctx, structVar)
 				return 1
 			}
 			fv = structVar.MethodByName(name)
 			if !fv.IsValid() {
-				C.lua_pushnil(ctx)
+				pushUnknownField(ctx, structVar)
 				return 1
 			}
 		}
 		if fv.CanInterface() {
-			pushValueWithMetatable(ctx, fv.Interface(), goFuncMeta)
+			pushGoMethod(ctx, fv)
 			return 1
 		}
 		C.lua_pushnil(ctx)
@@ -242,7 +358,12 @@ func go_struct_get(ctx *C.lua_State, structVar reflect.Value) C.int {
 		C.lua_pushnil(ctx)
 		return 1
 	}
-	pushLuaMetaValue(ctx, fv.Interface())
+	if isReadOnlyField(sf) {
+		// the value of a read-only field can't be changed through it either
+		pushGoValue(ctx, fv.Interface(), true)
+		return 1
+	}
+	pushElemValue(ctx, fv.Interface())
 	return 1
 }
 
@@ -251,17 +372,9 @@ func go_struct_set(ctx *C.lua_State, vv reflect.Value) C.int {
 	// [2]: key
 	// [3]: val
 	if C.lua_isstring(ctx, 2) == 0 {
-		pushString(ctx, "string expected")
-		C.lua_error(ctx)
-		return 1
+		return luaError(ctx, "string expected")
 	}
 	key := C.GoString(C.lua_tolstring(ctx, 2, (*C.ulong)(unsafe.Pointer(nil))))
-	goVal, err := fromLuaValue(ctx)
-	if err != nil {
-		pushString(ctx, err.Error())
-		C.lua_error(ctx)
-		return 1
-	}
 
 	var structE reflect.Value
 	switch vv.Kind() {
@@ -269,43 +382,217 @@ func go_struct_set(ctx *C.lua_State, vv reflect.Value) C.int {
 		structE = vv
 	case reflect.Ptr:
 		if vv.Elem().Kind() != reflect.Struct {
-			pushString(ctx, "pointer of struct expected")
-			C.lua_error(ctx)
-			return 1
+			return luaError(ctx, "pointer of struct expected")
 		}
 		structE = vv.Elem()
 	default:
-		pushString(ctx, "unsupported type")
-		C.lua_error(ctx)
-		return 1
+		return luaError(ctx, "unsupported type")
 	}
-	name := upperFirst(key)
-	fv := structE.FieldByName(name)
-	if !fv.IsValid() {
-		// pushString(ctx, fmt.Sprintf("%s not found", key))
-		msg := fmt.Sprintf("------\nkey \"%s\" not found", key)
-		var cMsg *C.char
-		getStrPtr(&msg, &cMsg)
-		C.luaL_traceback(ctx, ctx, cMsg, 1)
-		C.fputs(C.lua_tolstring(ctx, -1, (*C.ulong)(unsafe.Pointer(nil))), C.stderr)
-		fmt.Fprintf(os.Stderr, "\n------\n")
-		C.lua_error(ctx)
-		return 1
+	sf, fv, found := luaStructField(structE, key, true)
+	if found && !fv.IsValid() {
+		return luaError(ctx, fmt.Sprintf("field %s is promoted through a nil embedded pointer", key))
 	}
-	if _, ok := goVal.(string); ok {
-		goVal = fmt.Sprintf("%s", goVal) // deep copy
+	if found && isReadOnlyField(sf) {
+		return luaError(ctx, fmt.Sprintf("field %s is read-only", key))
 	}
-	if err = elutils.SetValue(fv, goVal); err != nil {
-		pushString(ctx, err.Error())
-		C.lua_error(ctx)
-		return 1
+	if found && hasReadOnlyFields(fv.Type()) {
+		return luaError(ctx, fmt.Sprintf("field %s has read-only fields", key))
+	}
+	if found && !fv.CanSet() {
+		return luaError(ctx, fmt.Sprintf("field %s cannot be set", key))
+	}
+	if !found {
+		return setUnknownField(ctx, vv, key)
+	}
+	if err := decodeLuaValue(ctx, fv, key); err != nil {
+		return luaError(ctx, err.Error())
 	}
 	return 0
 }
 
+// goMethod is a method bound to its receiver, which is the Go value of the proxy
+// with index recvIdx in the ptrStore.
+type goMethod struct {
+	fn reflect.Value
+	recvIdx uint32
+	recvType reflect.Type
+	readOnly bool // the receiver is read-only, so are the results
+}
+
+func pushGoMethod(ctx *C.lua_State, fn reflect.Value) {
+	// [1]: go_meta_proxy of the receiver
+	m := &goMethod{fn: fn, readOnly: isReadOnlyProxy(ctx, 1) || isReadOnlyContext(ctx)}
+	m.recvIdx, _ = getTargetIdx(ctx, 1)
+	if recv, ok := getTargetValue(ctx, 1); ok && recv != nil {
+		m.recvType = reflect.TypeOf(recv)
+	}
+	pushValueWithMetatable(ctx, m, goFuncMeta)
+}
+
+// isSelfArg checks if the Lua arg at argIdx is the receiver of m passed by calling
+// the method with colon syntax `obj:method(...)`. The receiver passed explicitly as
+// an arg, such as `obj.method(obj)`, is kept if the method accepts it.
+func (m *goMethod) isSelfArg(ctx *C.lua_State, argIdx C.int) bool {
+	if C.lua_type(ctx, argIdx) != C.LUA_TUSERDATA {
+		return false
+	}
+	if idx, ok := getTargetIdx(ctx, argIdx); !ok || idx != m.recvIdx {
+		return false
+	}
+
+	fnType := m.fn.Type()
+	argc := int(C.lua_gettop(ctx) - argIdx) + 1
+	if !fnType.IsVariadic() {
+		return argc == fnType.NumIn() + 1
+	}
+	firstType := fnType.In(0)
+	if fnType.NumIn() == 1 {
+		firstType = firstType.Elem()
+	}
+	return m.recvType == nil || !m.recvType.AssignableTo(firstType)
+}
+
+// UnknownFieldPolicy decides what happens when Lua sets a field not found in a Go struct.
+// It also applies to the keys of a Lua table decoded into a Go struct, where a stored
+// field is dropped, and a non-string key is unknown.
+type UnknownFieldPolicy int
+const (
+	UnknownFieldAsError UnknownFieldPolicy = iota // default
+	UnknownFieldIgnore
+	UnknownFieldStore // stored in Lua for the pointer of the struct, as long as the context lives
+)
+
+// WithUnknownFields sets the policy of setting fields not found in Go structs.
+func WithUnknownFields(policy UnknownFieldPolicy) Option {
+	return func(o *options) {
+		o.unknownFields = policy
+	}
+}
+
+func getUnknownFieldPolicy(ctx *C.lua_State) UnknownFieldPolicy {
+	if env := getCtxEnv(ctx); env != nil {
+		return env.opts.unknownFields
+	}
+	return UnknownFieldAsError
+}
+
+var unknownFieldsName = "luago.fields\x00"
+
+// pushUnknownFields pushes the table of the unknown fields stored for the struct pointed
+// to by structVar, or nil. The tables are kept in the registry by the pointers, as every
+// push of a Go value makes a new proxy.
+func pushUnknownFields(ctx *C.lua_State, structVar reflect.Value, create bool) {
+	if structVar.Kind() != reflect.Ptr {
+		C.lua_pushnil(ctx) // [ ... nil ], a struct value is a copy, with no fields stored
+		return
+	}
+	var name *C.char
+	getStrPtr(&unknownFieldsName, &name)
+	if C.lua_getfield(ctx, C.LUA_REGISTRYINDEX, name) != C.LUA_TTABLE { // [ ... all ]
+		if !create {
+			return // [ ... nil ]
+		}
+		C.popN(ctx, 1) // [ ... ]
+		C.lua_createtable(ctx, 0, 0) // [ ... all ]
+		C.lua_pushvalue(ctx, -1) // [ ... all all ]
+		C.lua_setfield(ctx, C.LUA_REGISTRYINDEX, name) // [ ... all ]
+	}
+	ptr := C.lua_Integer(structVar.Pointer()) // not passed as a pointer, which cgo would check
+	if C.lua_rawgeti(ctx, -1, ptr) != C.LUA_TTABLE && create { // [ ... all fields ]
+		C.popN(ctx, 1) // [ ... all ]
+		C.lua_createtable(ctx, 1, 1) // [ ... all fields ]
+		// fields[1] keeps the proxy, so the Go value isn't collected and its address
+		// isn't reused while its fields are stored.
+		C.lua_pushvalue(ctx, 1) // [ ... all fields proxy ]
+		C.lua_rawseti(ctx, -2, 1) // [ ... all fields ]
+		C.lua_pushvalue(ctx, -1) // [ ... all fields fields ]
+		C.lua_rawseti(ctx, -3, ptr) // [ ... all fields ] with all[ptr] = fields
+	}
+	C.lua_rotate(ctx, -2, 1) // [ ... fields all ]
+	C.popN(ctx, 1) // [ ... fields ]
+}
+
+// pushUnknownField pushes the value of an unknown field stored for the struct pointed
+// to by structVar, or nil.
+func pushUnknownField(ctx *C.lua_State, structVar reflect.Value) {
+	// [1]: go_meta_proxy
+	// [2]: key
+	if getUnknownFieldPolicy(ctx) != UnknownFieldStore {
+		C.lua_pushnil(ctx) // [ ... nil ]
+		return
+	}
+	pushUnknownFields(ctx, structVar, false) // [ ... fields ]
+	if C.lua_type(ctx, -1) != C.LUA_TTABLE {
+		// [ ... nil ]
+		return
+	}
+	C.lua_pushvalue(ctx, 2) // [ ... fields key ]
+	C.lua_rawget(ctx, -2) // [ ... fields value ]
+	C.lua_rotate(ctx, -2, 1) // [ ... value fields ]
+	C.popN(ctx, 1) // [ ... value ]
+}
+
+// setUnknownField handles setting a field not found according to the UnknownFieldPolicy.
+func setUnknownField(ctx *C.lua_State, structVar reflect.Value, key string) C.int {
+	// [1]: go_meta_proxy
+	// [2]: key
+	// [3]: val
+	switch getUnknownFieldPolicy(ctx) {
+	case UnknownFieldIgnore:
+		return 0
+	case UnknownFieldStore:
+		if structVar.Kind() != reflect.Ptr {
+			return luaError(ctx, fmt.Sprintf("field %s cannot be set", key))
+		}
+		pushUnknownFields(ctx, structVar, true) // [ ... fields ]
+		C.lua_pushvalue(ctx, 2) // [ ... fields key ]
+		C.lua_pushvalue(ctx, 3) // [ ... fields key val ]
+		C.lua_rawset(ctx, -3) // [ ... fields ] with fields[key] = val
+		C.popN(ctx, 1) // [ ... ]
+		return 0
+	default:
+		return luaError(ctx, fmt.Sprintf("field %s not found", key))
+	}
+}
+
+// structField finds the field of a struct by name, including the fields promoted from
+// embedded structs. found is true with an invalid fv if the field is promoted through
+// a nil embedded pointer, which is allocated if alloc is true and it can be set.
+func structField(structE reflect.Value, name string, alloc bool) (fv reflect.Value, found bool) {
+	f, ok := structE.Type().FieldByName(name)
+	if !ok {
+		return
+	}
+	found = true
+	fv = structE
+	for i, idx := range f.Index {
+		if i > 0 && fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				if !alloc || !fv.CanSet() {
+					fv = reflect.Value{}
+					return
+				}
+				fv.Set(reflect.New(fv.Type().Elem()))
+			}
+			fv = fv.Elem()
+		}
+		fv = fv.Field(idx)
+	}
+	return
+}
+
+// go_interface_get unwraps an interface value to its dynamic value, so the fields of
+// a struct are accessible as well as the methods.
 func go_interface_get(ctx *C.lua_State, vv reflect.Value) C.int {
 	// [1]: ...
 	// [2]: key
+	if vv.IsNil() {
+		C.lua_pushnil(ctx)
+		return 1
+	}
+	if elem := vv.Elem(); elem.Kind() == reflect.Struct || (elem.Kind() == reflect.Ptr && elem.Elem().Kind() == reflect.Struct) {
+		return go_struct_get(ctx, elem)
+	}
 	if C.lua_isstring(ctx, 2) == 0 {
 		C.lua_pushnil(ctx)
 		return 1
@@ -317,7 +604,7 @@ func go_interface_get(ctx *C.lua_State, vv reflect.Value) C.int {
 		C.lua_pushnil(ctx)
 		return 1
 	}
-	pushValueWithMetatable(ctx, fv.Interface(), goFuncMeta)
+	pushGoMethod(ctx, fv)
 	return 1
 }
 
@@ -337,7 +624,7 @@ func getTargetValue(ctx *C.lua_State, targetIdx C.int) (v interface{}, ok bool)
 		return
 	}
 
-	ptr := getPtrStore(uintptr(unsafe.Pointer(ctx)))
+	ptr := getCtxPtrStore(ctx)
 	vPtr, o := ptr.lookup(idx)
 	if !o {
 		ok = false
@@ -382,16 +669,15 @@ func go_obj_set(ctx *C.lua_State) C.int {
 	// [ 1 ] go_meta_proxy
 	// [ 2 ] key
 	// [ 3 ] value
+	if isReadOnlyProxy(ctx, 1) {
+		return readOnlyError(ctx)
+	}
 	v, ok := getTargetValue(ctx, 1)
 	if !ok {
-		pushString(ctx, "no target found")
-		C.lua_error(ctx)
-		return 1
+		return luaError(ctx, "no target found")
 	}
 	if v == nil {
-		pushString(ctx, "no value")
-		C.lua_error(ctx)
-		return 1
+		return luaError(ctx, "no value")
 	}
 	switch vv := reflect.ValueOf(v); vv.Kind() {
 	case reflect.Slice, reflect.Array:
@@ -400,10 +686,13 @@ func go_obj_set(ctx *C.lua_State) C.int {
 		return go_map_set(ctx, vv)
 	case reflect.Struct, reflect.Ptr:
 		return go_struct_set(ctx, vv)
+	case reflect.Interface:
+		if !vv.IsNil() {
+			return go_struct_set(ctx, vv.Elem())
+		}
+		return luaError(ctx, "no value")
 	default:
-		pushString(ctx, "unsupport value type")
-		C.lua_error(ctx)
-		return 1
+		return luaError(ctx, "unsupport value type")
 	}
 }
 
@@ -420,7 +709,7 @@ func go_obj_len(ctx *C.lua_State) C.int {
 		return 1
 	}
 	switch vv := reflect.ValueOf(v); vv.Kind() {
-	case reflect.Slice, reflect.Array, reflect.Map:
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Chan:
 		C.lua_pushinteger(ctx, C.lua_Integer(vv.Len()))
 		return 1
 	case reflect.Struct:
@@ -447,47 +736,33 @@ func go_func_call(ctx *C.lua_State) C.int {
 	// [ 2 ~ top ] args
 	v, ok := getTargetValue(ctx, 1)
 	if !ok {
-		pushString(ctx, "not found")
-		C.lua_error(ctx)
-		return 1
+		return luaError(ctx, "not found")
 	}
 	if v == nil {
-		pushString(ctx, "wrong type")
-		C.lua_error(ctx)
-		return 1
+		return luaError(ctx, "wrong type")
 	}
 
-	fnVal := reflect.ValueOf(v)
-	if fnVal.Kind() != reflect.Func {
-		pushString(ctx, "go function expected")
-		C.lua_error(ctx)
-		return 1
-	}
-	fnType := fnVal.Type()
-
-	// make args for Golang function
-	helper := elutils.NewGolangFuncHelperDirectly(fnVal, fnType)
-	argc := int(C.lua_gettop(ctx)) - 1
-	// [ arg1 arg2 ... argN ]
-	getArgs := func(i int) interface{} {
-		C.lua_pushnil(ctx)  // [ args ... null ] 
-		C.lua_copy(ctx, C.int(i + 2), -1) // [ args ... argI ]  i is 0-based, lua is 1-based
-		defer C.popN(ctx, 1) // [ args ... ]
-
-		if goVal, err := fromLuaValue(ctx); err == nil {
-			return goVal
+	argIdx := 2
+	var fnVal reflect.Value
+	readOnly := isReadOnlyContext(ctx)
+	if m, ok := v.(*goMethod); ok {
+		fnVal = m.fn
+		readOnly = m.readOnly
+		if m.isSelfArg(ctx, 2) {
+			argIdx = 3 // called with colon syntax, the receiver is dropped
 		}
-		return nil
+	} else {
+		fnVal = reflect.ValueOf(v)
+	}
+	if fnVal.Kind() != reflect.Func {
+		return luaError(ctx, "go function expected")
 	}
-	res, e := helper.CallGolangFunc(argc, "lua-func", getArgs) // call Golang function
+	res, e := callGoFunc(ctx, fnVal, argIdx) // call Golang function with args [ argIdx ~ top ]
 
 	// convert result (in var v) of Golang function to that of Lua.
 	// 1. error
 	if e != nil {
-		es := e.Error()
-		pushString(ctx, es)
-		C.lua_error(ctx)
-		return 1
+		return luaError(ctx, e.Error())
 	}
 
 	// 2. no result
@@ -496,7 +771,7 @@ func go_func_call(ctx *C.lua_State) C.int {
 	}
 
 	// 3. array or scalar
-	pushLuaMetaValue(ctx, res)
+	pushGoValue(ctx, res, readOnly)
 	return 1
 }
 
@@ -505,7 +780,7 @@ func go_obj_free(ctx *C.lua_State) C.int {
 	// [ 1 ] go_meta_proxy
 	if idx, ok := getTargetIdx(ctx, 1); ok {
 		// fmt.Printf("---go_obj_free called\n")
-		ptr := getPtrStore(uintptr(unsafe.Pointer(ctx)))
+		ptr := getCtxPtrStore(ctx)
 		ptr.remove(idx)
 	}
 	return 0
@@ -532,27 +807,41 @@ func registerMetatable(ctx *C.lua_State, metaName string, methods ...*metaMethod
 }
 
 func registerGoMetatables(ctx *C.lua_State) {
-	registerMetatable(ctx, goObjMeta, &metaMethod{
-		name: __index, method: (C.lua_CFunction)(C.go_obj_get),
-	}, &metaMethod{
-		name: __newindex, method: (C.lua_CFunction)(C.go_obj_set),
-	}, &metaMethod{
-		name: __len, method: (C.lua_CFunction)(C.go_obj_len),
-	}, &metaMethod{
-		name: __gc, method: (C.lua_CFunction)(C.go_obj_free),
-	})
+	objMethods := []*metaMethod{
+		{name: __index, method: (C.lua_CFunction)(C.go_obj_get_wrap)},
+		{name: __newindex, method: (C.lua_CFunction)(C.go_obj_set_wrap)},
+		{name: __len, method: (C.lua_CFunction)(C.go_obj_len_wrap)},
+		{name: __gc, method: (C.lua_CFunction)(C.go_obj_free_wrap)},
+		{name: __tostring, method: (C.lua_CFunction)(C.go_obj_tostring_wrap)},
+		{name: __concat, method: (C.lua_CFunction)(C.go_obj_concat_wrap)},
+		{name: __eq, method: (C.lua_CFunction)(C.go_obj_eq_wrap)},
+		{name: __lt, method: (C.lua_CFunction)(C.go_obj_lt_wrap)},
+		{name: __le, method: (C.lua_CFunction)(C.go_obj_le_wrap)},
+		{name: __add, method: (C.lua_CFunction)(C.go_obj_add_wrap)},
+		{name: __sub, method: (C.lua_CFunction)(C.go_obj_sub_wrap)},
+		{name: __mul, method: (C.lua_CFunction)(C.go_obj_mul_wrap)},
+		{name: __div, method: (C.lua_CFunction)(C.go_obj_div_wrap)},
+		{name: __mod, method: (C.lua_CFunction)(C.go_obj_mod_wrap)},
+		{name: __pow, method: (C.lua_CFunction)(C.go_obj_pow_wrap)},
+		{name: __idiv, method: (C.lua_CFunction)(C.go_obj_idiv_wrap)},
+		{name: __unm, method: (C.lua_CFunction)(C.go_obj_unm_wrap)},
+	}
+	registerMetatable(ctx, goObjMeta, objMethods...)
+	registerMetatable(ctx, goObjROMeta, objMethods...)
 
 	registerMetatable(ctx, goFuncMeta, &metaMethod{
-		name: __call, method: (C.lua_CFunction)(C.go_func_call),
+		name: __call, method: (C.lua_CFunction)(C.go_func_call_wrap),
 	}, &metaMethod{
-		name: __gc, method: (C.lua_CFunction)(C.go_obj_free),
+		name: __gc, method: (C.lua_CFunction)(C.go_obj_free_wrap),
 	})
+
+	registerBytesMetatable(ctx)
 }
 
 func pushValueWithMetatable(ctx *C.lua_State, v interface{}, metaName string) {
 	var name *C.char
 
-	ptr := getPtrStore(uintptr(unsafe.Pointer(ctx)))
+	ptr := getCtxPtrStore(ctx)
 	idx := ptr.register(&v)
 
 	p := (*uint32)(C.lua_newuserdatauv(ctx, 4, 0))   // [ userdata ]
@@ -562,6 +851,16 @@ func pushValueWithMetatable(ctx *C.lua_State, v interface{}, metaName string) {
 	C.luaL_setmetatable(ctx, name) // [ userdata ] with metatable
 }
 
+// pushes the error message with the position of the Lua caller, just like luaL_error(),
+// and returns -1, so the error is raised by the C wrapper of the exported function.
+// see go-meta-wrap.c
+func luaError(ctx *C.lua_State, msg string) C.int {
+	C.luaL_where(ctx, 1) // [ ... where ]
+	pushString(ctx, msg) // [ ... where msg ]
+	C.lua_concat(ctx, 2) // [ ... where..msg ]
+	return -1
+}
+
 func pushString(ctx *C.lua_State, s string) {
 	var cstr *C.char
 	var sLen C.int
@@ -573,3 +872,7 @@ func upperFirst(name string) string {
 	return strings.ToUpper(name[:1]) + name[1:]
 }
 
+
+func lowerFirst(name string) string {
+	return strings.ToLower(name[:1]) + name[1:]
+}
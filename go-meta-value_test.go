@@ -0,0 +1,206 @@
+package lua
+
+import (
+	"reflect"
+	"testing"
+)
+
+type testColor string
+
+func TestMapKeys(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ints := map[int]string{1: "a"}
+	colors := map[testColor]int{"red": 1}
+	flags := map[bool]string{true: "yes"}
+	floats := map[float64]string{1.5: "x"}
+	err = ctx.LoadScript(`
+		assert(ints[1] == "a" and ints[2] == nil)
+		ints[2] = "b"
+		assert(colors.red == 1)
+		colors.blue = 2
+		assert(flags[true] == "yes")
+		flags[false] = "no"
+		assert(floats[1.5] == "x")
+		floats[2] = "y"
+	`, map[string]interface{}{"ints": ints, "colors": colors, "flags": flags, "floats": floats})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ints[2] != "b" || colors["blue"] != 2 || flags[false] != "no" || floats[2] != "y" {
+		t.Fatalf("unexpected maps %v %v %v %v", ints, colors, flags, floats)
+	}
+
+	for _, script := range []string{
+		`ints.x = "c"`,
+		`ints[1.5] = "c"`,
+		`flags[1] = "c"`,
+	} {
+		if err = ctx.LoadScript(script, map[string]interface{}{"ints": ints, "flags": flags}); err == nil {
+			t.Fatalf("%s: error expected", script)
+		}
+	}
+}
+
+func TestTableKeys(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := getGlobalOf(t, ctx, `{[true] = 1, [1.5] = "f", [-1] = "n", x = "s"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[interface{}]interface{}{true: 1.0, 1.5: "f", int64(-1): "n", "x": "s"}
+	if !reflect.DeepEqual(v, want) {
+		t.Fatalf("%#v expected, got %#v", want, v)
+	}
+
+	var got map[interface{}]interface{}
+	err = ctx.LoadScript(`keep({[false] = "b"})`, map[string]interface{}{
+		"keep": func(m map[interface{}]interface{}) { got = m },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[false] != "b" {
+		t.Fatalf("unexpected map %v", got)
+	}
+}
+
+type testCounter struct {
+	N int
+}
+
+func (c testCounter) Get() int {
+	return c.N
+}
+
+func (c *testCounter) Add(n int) int {
+	c.N += n
+	return c.N
+}
+
+func (c *testCounter) Any(args ...interface{}) int {
+	return len(args)
+}
+
+type testGetter interface {
+	Get() int
+}
+
+func TestMethodCalls(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &testCounter{}
+	err = ctx.LoadScript(`
+		assert(p.add(1) == 1 and p:add(2) == 3)
+		assert(p.get() == 3 and p:get() == 3)
+		assert(v.get() == 5 and v:get() == 5)
+		assert(s.g.get() == 7 and s.g:get() == 7)
+		assert(p.any(1, 2) == 2 and p:any(1, 2) == 3)
+		assert(not pcall(p.add, "x"))
+	`, map[string]interface{}{
+		"p": c,
+		"v": testCounter{5},
+		"s": &struct{ G testGetter }{testCounter{7}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.N != 3 {
+		t.Fatalf("unexpected counter %d", c.N)
+	}
+}
+
+// EmbeddedBase is exported, so a nil pointer of it embedded can be allocated.
+type EmbeddedBase struct {
+	ID int
+}
+
+func (b *EmbeddedBase) Describe() string {
+	return "base"
+}
+
+type testDerived struct {
+	*EmbeddedBase
+	Name  string
+	Value interface{}
+}
+
+func (d *testDerived) Describe() string {
+	return "derived " + d.Name
+}
+
+func TestEmbeddedFields(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &testDerived{Name: "d", Value: &testCounter{N: 2}}
+	err = ctx.LoadScript(`
+		assert(d.iD == nil)
+		d.iD = 1
+		assert(d.iD == 1)
+		assert(d:describe() == "derived d")
+		assert(d.value.n == 2 and d.value:add(1) == 3)
+	`, map[string]interface{}{"d": d})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.EmbeddedBase == nil || d.ID != 1 {
+		t.Fatalf("embedded struct not allocated: %+v", d)
+	}
+
+	err = ctx.LoadScript(`v.n = 1`, map[string]interface{}{"v": testCounter{}})
+	if err == nil {
+		t.Fatal("error expected setting a field of a struct value")
+	}
+}
+
+type testTree struct {
+	Name string
+	Sub *testTree
+}
+
+func TestUnknownFields(t *testing.T) {
+	tree := &testTree{Name: "root", Sub: &testTree{Name: "sub"}}
+	env := map[string]interface{}{"tree": tree, "value": *tree}
+
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ctx.LoadScript(`tree.extra = 1`, env); err == nil {
+		t.Fatal("error expected")
+	}
+
+	ctx, err = NewContext(WithUnknownFields(UnknownFieldIgnore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ctx.LoadScript(`tree.extra = 1; assert(tree.extra == nil)`, env); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, err = NewContext(WithUnknownFields(UnknownFieldStore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every access of tree.sub makes a new proxy of the same struct
+	err = ctx.LoadScript(`
+		tree.sub.extra = 1
+		assert(tree.sub.extra == 1 and tree.extra == nil)
+		assert(not pcall(function() value.extra = 1 end))
+	`, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ctx.LoadScript(`assert(tree.sub.extra == 1)`, map[string]interface{}{"tree": tree}); err != nil {
+		t.Fatal(err)
+	}
+}
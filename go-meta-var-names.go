@@ -3,10 +3,24 @@ package lua
 var (
 	goObjMeta  = "goObjMeta\x00"
 	goFuncMeta = "goFuncMeta\x00"
+	goObjROMeta = "goObjROMeta\x00" // goObjMeta of read-only values
 
 	__index    = "__index\x00"
 	__newindex = "__newindex\x00"
 	__len      = "__len\x00"
 	__call     = "__call\x00"
 	__gc       = "__gc\x00"
+	__tostring = "__tostring\x00"
+	__concat   = "__concat\x00"
+	__eq       = "__eq\x00"
+	__lt       = "__lt\x00"
+	__le       = "__le\x00"
+	__add      = "__add\x00"
+	__sub      = "__sub\x00"
+	__mul      = "__mul\x00"
+	__div      = "__div\x00"
+	__mod      = "__mod\x00"
+	__pow      = "__pow\x00"
+	__idiv     = "__idiv\x00"
+	__unm      = "__unm\x00"
 )
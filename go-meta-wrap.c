@@ -0,0 +1,92 @@
+/*
+ * C wrappers of the Go functions exported as Lua C functions.
+ *
+ * lua_error() uses longjmp(), which must not unwind Go frames. So an exported Go
+ * function pushes the error message and returns -1 instead of raising the error,
+ * and the error is raised here after the Go function returned.
+ */
+#include "lua.h"
+#include "lauxlib.h"
+#include "_cgo_export.h"
+
+#define WRAP_GO_FUNC(name) \
+	int name##_wrap(lua_State *L) { \
+		int n = name(L); \
+		if (n < 0) { \
+			return lua_error(L); \
+		} \
+		return n; \
+	}
+
+WRAP_GO_FUNC(go_obj_get)
+WRAP_GO_FUNC(go_obj_set)
+WRAP_GO_FUNC(go_obj_len)
+WRAP_GO_FUNC(go_func_call)
+WRAP_GO_FUNC(go_obj_free)
+WRAP_GO_FUNC(go_bytes_get)
+WRAP_GO_FUNC(go_bytes_set)
+WRAP_GO_FUNC(go_bytes_tostring)
+WRAP_GO_FUNC(go_bytes_sub)
+WRAP_GO_FUNC(go_chan_compile)
+WRAP_GO_FUNC(go_chan_poll)
+WRAP_GO_FUNC(go_chan_wait)
+WRAP_GO_FUNC(go_chan_close)
+WRAP_GO_FUNC(go_async_result)
+WRAP_GO_FUNC(go_obj_tostring)
+WRAP_GO_FUNC(go_obj_concat)
+WRAP_GO_FUNC(go_obj_eq)
+WRAP_GO_FUNC(go_obj_lt)
+WRAP_GO_FUNC(go_obj_le)
+WRAP_GO_FUNC(go_obj_add)
+WRAP_GO_FUNC(go_obj_sub)
+WRAP_GO_FUNC(go_obj_mul)
+WRAP_GO_FUNC(go_obj_div)
+WRAP_GO_FUNC(go_obj_mod)
+WRAP_GO_FUNC(go_obj_pow)
+WRAP_GO_FUNC(go_obj_idiv)
+WRAP_GO_FUNC(go_obj_unm)
+WRAP_GO_FUNC(go_msg_handler)
+WRAP_GO_FUNC(go_print)
+WRAP_GO_FUNC(go_stdio_write)
+WRAP_GO_FUNC(go_stdio_read)
+WRAP_GO_FUNC(go_log)
+WRAP_GO_FUNC(go_json_encode)
+WRAP_GO_FUNC(go_json_decode)
+WRAP_GO_FUNC(go_re_compile)
+WRAP_GO_FUNC(go_re_match)
+WRAP_GO_FUNC(go_re_find)
+WRAP_GO_FUNC(go_re_find_all)
+WRAP_GO_FUNC(go_re_gsub)
+WRAP_GO_FUNC(go_re_split)
+WRAP_GO_FUNC(go_re_tostring)
+WRAP_GO_FUNC(go_time_func)
+WRAP_GO_FUNC(go_fs_func)
+WRAP_GO_FUNC(go_http_request)
+WRAP_GO_FUNC(go_crypto_func)
+WRAP_GO_FUNC(go_config_include)
+
+/*
+ * the count hook set by setCancelHook(), which raises the error of the Go context
+ * once it is done.
+ */
+void cancelHook(lua_State *L, lua_Debug *ar) {
+	if (go_ctx_canceled(L)) {
+		lua_error(L);
+	}
+}
+
+static int toString(lua_State *L) {
+	luaL_tolstring(L, 1, NULL);
+	return 1;
+}
+
+/*
+ * luaL_tolstring() in protected mode, as __tostring may raise an error. the string
+ * is pushed if LUA_OK is returned, otherwise the error is pushed.
+ */
+int protectedToString(lua_State *L, int idx) {
+	idx = lua_absindex(L, idx);
+	lua_pushcfunction(L, toString);
+	lua_pushvalue(L, idx);
+	return lua_pcall(L, 1, 1, 0);
+}
@@ -0,0 +1,98 @@
+package lua
+
+// #include "lua.h"
+// #include "lauxlib.h"
+import "C"
+import (
+	"reflect"
+	"strings"
+	"unsafe"
+	"fmt"
+)
+
+type readOnlyValue struct {
+	v interface{}
+}
+
+// ReadOnly wraps a Go value passed to Lua, so that it and the fields and elements
+// read from it can't be set by Lua. Methods of the value can still be called, but
+// the values returned by them are read-only too. A read-only Bytes can't be set,
+// and a read-only channel is passed as a receive-only channel, so it can't be sent
+// to or closed by Lua, except a send-only channel which is kept as it is.
+func ReadOnly(v interface{}) interface{} {
+	return &readOnlyValue{v: v}
+}
+
+func isReadOnlyProxy(ctx *C.lua_State, idx C.int) bool {
+	var name *C.char
+	getStrPtr(&goObjROMeta, &name)
+	if C.luaL_testudata(ctx, idx, name) != nil {
+		return true
+	}
+	getStrPtr(&goBytesROMeta, &name)
+	return C.luaL_testudata(ctx, idx, name) != nil
+}
+
+func isReadOnlyContext(ctx *C.lua_State) bool {
+	if env := getCtxEnv(ctx); env != nil {
+		return env.opts.readOnly
+	}
+	return false
+}
+
+// a field with tag `lua:",readonly"` can't be set by Lua
+func isReadOnlyField(f reflect.StructField) bool {
+	return hasTagOption(f, "readonly")
+}
+
+// hasReadOnlyFields checks if a value of type t has read-only fields in it, which
+// would be overwritten if the value is replaced as a whole.
+func hasReadOnlyFields(t reflect.Type) bool {
+	return findReadOnlyFields(t, make(map[reflect.Type]bool))
+}
+
+func findReadOnlyFields(t reflect.Type, visited map[reflect.Type]bool) bool {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || visited[t] {
+		return false
+	}
+	visited[t] = true
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); isReadOnlyField(f) || findReadOnlyFields(f.Type, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasTagOption checks the options following the name in the tag `lua:"name,opt1,opt2"`.
+func hasTagOption(f reflect.StructField, opt string) bool {
+	tag, ok := f.Tag.Lookup("lua")
+	if !ok {
+		return false
+	}
+	opts := strings.Split(tag, ",")
+	for _, o := range opts[1:] {
+		if strings.TrimSpace(o) == opt {
+			return true
+		}
+	}
+	return false
+}
+
+// readOnlyError returns the error of setting a key of the read-only proxy at index 1.
+func readOnlyError(ctx *C.lua_State) C.int {
+	// [ 1 ] go_meta_proxy
+	// [ 2 ] key
+	var key string
+	switch C.lua_type(ctx, 2) {
+	case C.LUA_TSTRING, C.LUA_TNUMBER:
+		C.lua_pushvalue(ctx, 2)
+		key = C.GoString(C.lua_tolstring(ctx, -1, (*C.ulong)(unsafe.Pointer(nil))))
+	default:
+		key = C.GoString(C.lua_typename(ctx, C.lua_type(ctx, 2)))
+	}
+	return luaError(ctx, fmt.Sprintf("cannot set %s of read-only value", key))
+}
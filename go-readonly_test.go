@@ -0,0 +1,140 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+)
+
+type testInner struct {
+	X int
+}
+
+type testOuter struct {
+	Name string
+	Inner *testInner `lua:",readonly"`
+	Free *testInner
+	Buf Bytes
+	Ch chan int
+}
+
+func (o *testOuter) GetFree() *testInner {
+	return o.Free
+}
+
+func newTestOuter() *testOuter {
+	return &testOuter{
+		Inner: &testInner{1},
+		Free: &testInner{2},
+		Buf: Bytes("ab"),
+		Ch: make(chan int, 1),
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := newTestOuter()
+	env := map[string]interface{}{"ro": ReadOnly(o)}
+	for _, script := range []string{
+		`ro.name = "x"`,
+		`ro.free.x = 3`,
+		`ro:getFree().x = 3`,
+		`ro.buf[1] = 65`,
+		`ro.ch:send(1)`,
+		`ro.ch:close()`,
+	} {
+		err = ctx.LoadScript(script, env)
+		if err == nil {
+			t.Fatalf("%s: error expected", script)
+		}
+	}
+	if err = ctx.LoadScript(`assert(ro.free.x == 2 and ro.buf[1] == 97 and ro:getFree().x == 2)`, env); err != nil {
+		t.Fatal(err)
+	}
+	if o.Name != "" || o.Free.X != 2 || string(o.Buf) != "ab" || len(o.Ch) != 0 {
+		t.Fatalf("read-only value changed: %+v", o)
+	}
+}
+
+func TestReadOnlyField(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := newTestOuter()
+	env := map[string]interface{}{"o": o}
+	for _, script := range []string{
+		`o.inner = nil`,
+		`o.inner.x = 3`,
+	} {
+		err = ctx.LoadScript(script, env)
+		if err == nil || !strings.Contains(err.Error(), "read-only") {
+			t.Fatalf("%s: unexpected error %v", script, err)
+		}
+	}
+	err = ctx.LoadScript(`
+		o.name = "x"
+		o.free.x = 3
+		o.buf[1] = 65
+		o.ch:send(1)
+	`, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Inner.X != 1 || o.Name != "x" || o.Free.X != 3 || string(o.Buf) != "Ab" || len(o.Ch) != 1 {
+		t.Fatalf("unexpected value: %+v", o)
+	}
+}
+
+func TestWithReadOnly(t *testing.T) {
+	ctx, err := NewContext(WithReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := newTestOuter()
+	if err = ctx.LoadScript(`o.free.x = 3`, map[string]interface{}{"o": o}); err == nil {
+		t.Fatal("error expected")
+	}
+}
+
+type testSecret struct {
+	Key string `lua:",readonly"`
+}
+
+type testVault struct {
+	Secret testSecret
+	Ptr *testSecret
+	List [1]testSecret
+	ByName map[string]testSecret
+}
+
+func TestReadOnlyFieldReplaced(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &testVault{
+		Secret: testSecret{"s"},
+		Ptr: &testSecret{"p"},
+		List: [1]testSecret{{"l"}},
+		ByName: map[string]testSecret{"a": {"m"}},
+	}
+	env := map[string]interface{}{"v": v}
+	// the values with read-only fields can't be replaced as a whole
+	for _, script := range []string{
+		`v.secret = {key = "hacked"}`,
+		`v.ptr = {key = "hacked"}`,
+		`v.list[1] = {key = "hacked"}`,
+		`v.byName.a = {key = "hacked"}`,
+	} {
+		err = ctx.LoadScript(script, env)
+		if err == nil || !strings.Contains(err.Error(), "read-only") {
+			t.Fatalf("%s: unexpected error %v", script, err)
+		}
+	}
+	if v.Secret.Key != "s" || v.Ptr.Key != "p" || v.List[0].Key != "l" || v.ByName["a"].Key != "m" {
+		t.Fatalf("read-only field changed: %+v", v)
+	}
+}
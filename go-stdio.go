@@ -0,0 +1,372 @@
+package lua
+
+// #include <stdlib.h>
+// #include "lua.h"
+// #include "lauxlib.h"
+// static void popN(lua_State *L, int n);
+// extern int go_stdio_write_wrap(lua_State *ctx);
+// extern int go_stdio_read_wrap(lua_State *ctx);
+// extern int go_print_wrap(lua_State *ctx);
+// extern int protectedToString(lua_State *L, int idx);
+import "C"
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"unsafe"
+	"fmt"
+)
+
+// WithStdout makes `print`, `io.write` and `io.stdout` of Lua write to w.
+func WithStdout(w io.Writer) Option {
+	return func(o *options) {
+		o.stdout = w
+	}
+}
+
+// WithStderr makes `io.stderr` of Lua write to w.
+func WithStderr(w io.Writer) Option {
+	return func(o *options) {
+		o.stderr = w
+	}
+}
+
+// WithStdin makes `io.read`, `io.lines()` and `io.stdin` of Lua read from r.
+func WithStdin(r io.Reader) Option {
+	return func(o *options) {
+		o.stdin = r
+	}
+}
+
+// the standard files replaced by Go writers and readers, with the same methods as
+// Lua files except seek. They are tables rather than Lua files, so io.type(),
+// io.output(), io.input() and io.close() are replaced to know them.
+const stdioModule = `
+local prim, hasOut, hasErr, hasIn = ...
+
+local function stdFile(fd)
+	local f = {}
+	function f:write(...)
+		prim.write(fd, ...)
+		return self
+	end
+	function f:read(...)
+		return prim.read(fd, ...)
+	end
+	function f:lines(...)
+		local fmts = table.pack(...)
+		return function()
+			return prim.read(fd, table.unpack(fmts, 1, fmts.n))
+		end
+	end
+	function f:flush()
+		return self
+	end
+	function f:setvbuf()
+		return true
+	end
+	function f:close()
+		return nil, "cannot close standard file"
+	end
+	function f:seek()
+		return nil, "cannot seek standard file"
+	end
+	return setmetatable(f, {__name = "FILE*", __tostring = function() return "file (go)" end})
+end
+
+if hasOut then
+	print = prim.print
+end
+if not io then
+	return -- see WithSafeLibs()
+end
+-- the standard files are tables, so the functions of io checking files know them
+local stdFiles = {}
+local ioType, output, input, close, lines = io.type, io.output, io.input, io.close, io.lines
+function io.type(obj)
+	if stdFiles[obj] then
+		return "file"
+	end
+	return ioType(obj)
+end
+function io.close(file)
+	file = file or io.output()
+	if stdFiles[file] then
+		return file:close()
+	end
+	return close(file)
+end
+
+if hasOut then
+	io.stdout = stdFile(1)
+	stdFiles[io.stdout] = true
+	local current -- the default output set by io.output(), nil for io.stdout
+	function io.output(file)
+		if file == io.stdout then
+			current = nil
+		elseif file ~= nil then
+			current = output(file)
+		end
+		return current or io.stdout
+	end
+	function io.write(...)
+		return io.output():write(...)
+	end
+end
+if hasErr then
+	io.stderr = stdFile(2)
+	stdFiles[io.stderr] = true
+end
+if hasIn then
+	io.stdin = stdFile(0)
+	stdFiles[io.stdin] = true
+	local current -- the default input set by io.input(), nil for io.stdin
+	function io.input(file)
+		if file == io.stdin then
+			current = nil
+		elseif file ~= nil then
+			current = input(file)
+		end
+		return current or io.stdin
+	end
+	function io.read(...)
+		return io.input():read(...)
+	end
+	function io.lines(filename, ...)
+		if filename == nil then
+			return io.input():lines(...)
+		end
+		return lines(filename, ...)
+	end
+end
+`
+
+func registerStdio(ctx *C.lua_State) {
+	env := getCtxEnv(ctx)
+	if env == nil {
+		return
+	}
+	opts := &env.opts
+	if opts.stdout == nil && opts.stderr == nil && opts.stdin == nil {
+		return
+	}
+	if opts.stdin != nil {
+		env.stdin = bufio.NewReader(opts.stdin)
+	}
+
+	cstr := C.CString(stdioModule)
+	defer C.free(unsafe.Pointer(cstr))
+	if C.luaL_loadstring(ctx, cstr) != 0 {
+		C.popN(ctx, 1) // [ ]
+		return
+	}
+	// [ chunk ]
+	C.lua_createtable(ctx, 0, 3) // [ chunk prim ]
+	for fnName, fn := range map[string]C.lua_CFunction{
+		"write": (C.lua_CFunction)(C.go_stdio_write_wrap),
+		"read": (C.lua_CFunction)(C.go_stdio_read_wrap),
+		"print": (C.lua_CFunction)(C.go_print_wrap),
+	} {
+		pushString(ctx, fnName) // [ chunk prim name ]
+		C.lua_pushcclosure(ctx, fn, 0) // [ chunk prim name fn ]
+		C.lua_rawset(ctx, -3) // [ chunk prim ]
+	}
+	for _, has := range []bool{opts.stdout != nil, opts.stderr != nil, opts.stdin != nil} {
+		if has {
+			C.lua_pushboolean(ctx, 1)
+		} else {
+			C.lua_pushboolean(ctx, 0)
+		}
+	}
+	// [ chunk prim hasOut hasErr hasIn ]
+	if C.lua_pcallk(ctx, 4, 0, 0, 0, nil) != 0 {
+		C.popN(ctx, 1) // [ ]
+	}
+}
+
+func getStdWriter(ctx *C.lua_State, fd int) io.Writer {
+	env := getCtxEnv(ctx)
+	if env == nil {
+		return nil
+	}
+	switch fd {
+	case 1:
+		return env.opts.stdout
+	case 2:
+		return env.opts.stderr
+	default:
+		return nil
+	}
+}
+
+//export go_print
+func go_print(ctx *C.lua_State) C.int {
+	// [ 1 ~ n ] args
+	w := getStdWriter(ctx, 1)
+	if w == nil {
+		return 0
+	}
+	n := C.lua_gettop(ctx)
+	b := &bytes.Buffer{}
+	for i := C.int(1); i <= n; i++ {
+		if i > 1 {
+			b.WriteByte('\t')
+		}
+		if C.protectedToString(ctx, i) != C.LUA_OK {
+			// [ args... err ], raised again by the C wrapper
+			return -1
+		}
+		// [ args... s ]
+		var length C.size_t
+		s := C.lua_tolstring(ctx, -1, &length)
+		b.Write(C.GoBytes(unsafe.Pointer(s), C.int(length)))
+		C.popN(ctx, 1) // [ args... ]
+	}
+	b.WriteByte('\n')
+	if _, err := w.Write(b.Bytes()); err != nil {
+		return luaError(ctx, err.Error())
+	}
+	return 0
+}
+
+//export go_stdio_write
+func go_stdio_write(ctx *C.lua_State) C.int {
+	// [ 1 ] fd
+	// [ 2 ~ n ] strings or numbers
+	w := getStdWriter(ctx, int(C.lua_tointegerx(ctx, 1, nil)))
+	if w == nil {
+		return luaError(ctx, "file not writable")
+	}
+	n := C.lua_gettop(ctx)
+	for i := C.int(2); i <= n; i++ {
+		if t := C.lua_type(ctx, i); t != C.LUA_TSTRING && t != C.LUA_TNUMBER {
+			return luaError(ctx, fmt.Sprintf("bad argument #%d to 'write' (string expected, got %s)", i-1, C.GoString(C.lua_typename(ctx, t))))
+		}
+		C.lua_pushvalue(ctx, i) // [ args... arg ], numbers are converted in the copy
+		var length C.size_t
+		s := C.lua_tolstring(ctx, -1, &length)
+		_, err := w.Write(C.GoBytes(unsafe.Pointer(s), C.int(length)))
+		C.popN(ctx, 1) // [ args... ]
+		if err != nil {
+			return luaError(ctx, err.Error())
+		}
+	}
+	return 0
+}
+
+//export go_stdio_read
+func go_stdio_read(ctx *C.lua_State) C.int {
+	// [ 1 ] fd
+	// [ 2 ~ n ] formats
+	env := getCtxEnv(ctx)
+	if env == nil || env.stdin == nil || C.lua_tointegerx(ctx, 1, nil) != 0 {
+		return luaError(ctx, "file not readable")
+	}
+	r := env.stdin
+
+	n := C.lua_gettop(ctx)
+	if n == 1 {
+		pushString(ctx, "l") // [ fd "l" ]
+		n = 2
+	}
+	nres := C.int(0)
+	for i := C.int(2); i <= n; i++ {
+		var ok bool
+		if C.lua_type(ctx, i) == C.LUA_TNUMBER {
+			ok = readBytes(ctx, r, int(C.lua_tointegerx(ctx, i, nil)))
+		} else {
+			f := strings.TrimPrefix(C.GoString(C.lua_tolstring(ctx, i, nil)), "*")
+			if len(f) == 0 {
+				return luaError(ctx, fmt.Sprintf("bad argument #%d to 'read' (invalid format)", i-1))
+			}
+			switch f[0] {
+			case 'l', 'L':
+				ok = readLine(ctx, r, f[0] == 'L')
+			case 'a':
+				b, err := io.ReadAll(r)
+				if err != nil {
+					return luaError(ctx, err.Error())
+				}
+				pushString(ctx, string(b))
+				ok = true
+			case 'n':
+				ok = readNumber(ctx, r)
+			default:
+				return luaError(ctx, fmt.Sprintf("bad argument #%d to 'read' (invalid format)", i-1))
+			}
+		}
+		nres += 1
+		if !ok {
+			break // nil is pushed for the failed format
+		}
+	}
+	return nres
+}
+
+func readBytes(ctx *C.lua_State, r *bufio.Reader, n int) bool {
+	if n <= 0 {
+		if _, err := r.Peek(1); err != nil {
+			C.lua_pushnil(ctx)
+			return false
+		}
+		pushString(ctx, "")
+		return true
+	}
+	b := make([]byte, n)
+	l, _ := io.ReadFull(r, b)
+	if l == 0 {
+		C.lua_pushnil(ctx)
+		return false
+	}
+	pushString(ctx, string(b[:l]))
+	return true
+}
+
+func readLine(ctx *C.lua_State, r *bufio.Reader, keepNewline bool) bool {
+	line, err := r.ReadString('\n')
+	if err != nil && len(line) == 0 {
+		C.lua_pushnil(ctx)
+		return false
+	}
+	if !keepNewline {
+		line = strings.TrimSuffix(line, "\n")
+	}
+	pushString(ctx, line)
+	return true
+}
+
+func readNumber(ctx *C.lua_State, r *bufio.Reader) bool {
+	// skip spaces
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			C.lua_pushnil(ctx)
+			return false
+		}
+		if !strings.ContainsRune(" \t\r\n\f\v", rune(c)) {
+			r.UnreadByte()
+			break
+		}
+	}
+	b := &strings.Builder{}
+	for b.Len() < 200 {
+		c, err := r.ReadByte()
+		if err != nil {
+			break
+		}
+		if !strings.ContainsRune("0123456789+-.xXaAbBcCdDeEfFpP", rune(c)) {
+			r.UnreadByte()
+			break
+		}
+		b.WriteByte(c)
+	}
+	s := b.String()
+	cstr := C.CString(s)
+	defer C.free(unsafe.Pointer(cstr))
+	if C.lua_stringtonumber(ctx, cstr) == 0 {
+		C.lua_pushnil(ctx)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,71 @@
+package lua
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestStdio(t *testing.T) {
+	out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+	ctx, err := NewContext(WithStdout(out), WithStderr(errOut), WithStdin(strings.NewReader("line1\n42\nrest")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.LoadScript(`
+		print("hello", 1, nil, true)
+		io.write("a", 2, "\n")
+		io.stderr:write("oops")
+		local line = io.read()
+		local n = io.read("n")
+		print(line, n, io.read("a"))
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := out.String(); s != "hello\t1\tnil\ttrue\na2\nline1\t42\t\nrest\n" {
+		t.Fatalf("unexpected stdout: %q", s)
+	}
+	if s := errOut.String(); s != "oops" {
+		t.Fatalf("unexpected stderr: %q", s)
+	}
+}
+
+func TestStdioFiles(t *testing.T) {
+	out := &bytes.Buffer{}
+	ctx, err := NewContext(WithStdout(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.LoadScript(`
+		assert(io.type(io.stdout) == "file")
+		assert(io.output() == io.stdout)
+		assert(io.close() == nil)
+		io.output(io.stdout):write("x")
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := out.String(); s != "x" {
+		t.Fatalf("unexpected stdout: %q", s)
+	}
+}
+
+func TestPrintTostringError(t *testing.T) {
+	out := &bytes.Buffer{}
+	ctx, err := NewContext(WithStdout(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.LoadScript(`
+		local ok, err = pcall(print, setmetatable({}, {__tostring=function() error("x") end}))
+		assert(not ok and err:find("x"))
+		print("after")
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := out.String(); s != "after\n" {
+		t.Fatalf("unexpected stdout: %q", s)
+	}
+}
@@ -0,0 +1,122 @@
+package lua
+
+// #include "lua.h"
+// static void popN(lua_State *L, int n);
+// static void pushGlobal(lua_State *L);
+import "C"
+import (
+	"reflect"
+	"fmt"
+)
+
+// RegisterType makes a Go struct type a class named name in Lua.
+//
+// prototype is a value of the struct type, a pointer to it, or a factory function
+// returning one of them. In Lua, `name.new{x=1, y=2}` creates a pointer to a new struct
+// with its fields set from the table, as a table assigned to a struct field, so the
+// `lua` tags and the UnknownFieldPolicy apply.
+// `name(...)` calls the factory, or `name.new` if no factory is given. The functions in
+// statics are the static methods of the class.
+func (ctx *LuaContext) RegisterType(name string, prototype interface{}, statics ...map[string]interface{}) (err error) {
+	if prototype == nil {
+		err = fmt.Errorf("prototype must not be nil")
+		return
+	}
+	var factory reflect.Value
+	t := reflect.TypeOf(prototype)
+	if t.Kind() == reflect.Func {
+		if t.NumOut() == 0 {
+			err = fmt.Errorf("factory of %s must return a struct", name)
+			return
+		}
+		factory = reflect.ValueOf(prototype)
+		t = t.Out(0)
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		err = fmt.Errorf("struct or pointer of struct expected for type %s", name)
+		return
+	}
+
+	newFn := reflect.ValueOf(func(fields ...*LuaTable) (interface{}, error) {
+		p := reflect.New(t)
+		for _, f := range fields {
+			err := decodeLuaTable(f, p.Elem(), name)
+			f.Release()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return p.Interface(), nil
+	})
+	if !factory.IsValid() {
+		factory = newFn
+	}
+
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	c := ctx.c
+	C.pushGlobal(c) // [ global ]
+	pushString(c, name) // [ global name ]
+	C.lua_createtable(c, 0, 0) // [ global name class ]
+	for _, m := range statics {
+		for k, fn := range m {
+			pushString(c, k) // [ global name class k ]
+			pushLuaMetaValue(c, fn) // [ global name class k fn ]
+			C.lua_rawset(c, -3) // [ global name class ] with class[k] = fn
+		}
+	}
+	pushString(c, "new") // [ global name class "new" ]
+	pushLuaMetaValue(c, newFn.Interface()) // [ global name class "new" new ]
+	C.lua_rawset(c, -3) // [ global name class ] with class.new = new
+
+	C.lua_createtable(c, 0, 1) // [ global name class metatable ]
+	pushString(c, "__call") // [ global name class metatable "__call" ]
+	pushLuaMetaValue(c, classCall(factory).Interface()) // [ global name class metatable "__call" call ]
+	C.lua_rawset(c, -3) // [ global name class metatable ] with metatable.__call = call
+	C.lua_setmetatable(c, -2) // [ global name class ]
+
+	C.lua_rawset(c, -3) // [ global ] with global[name] = class
+	C.popN(c, 1) // [ ]
+	return
+}
+
+// decodeLuaTable decodes the table t into dest, name is the name of dest used in errors.
+func decodeLuaTable(t *LuaTable, dest reflect.Value, name string) (err error) {
+	t.env.mu.Lock()
+	defer t.env.mu.Unlock()
+
+	c, err := t.push() // [ table ]
+	if err != nil {
+		return
+	}
+	defer C.popN(c, 1) // [ ]
+	return decodeLuaValue(c, dest, name)
+}
+
+// classCall makes a function calling factory with the class table as the extra first arg.
+func classCall(factory reflect.Value) reflect.Value {
+	ft := factory.Type()
+	in := make([]reflect.Type, ft.NumIn()+1)
+	in[0] = luaTableType
+	for i := 0; i < ft.NumIn(); i++ {
+		in[i+1] = ft.In(i)
+	}
+	out := make([]reflect.Type, ft.NumOut())
+	for i := range out {
+		out[i] = ft.Out(i)
+	}
+	callT := reflect.FuncOf(in, out, ft.IsVariadic())
+	return reflect.MakeFunc(callT, func(args []reflect.Value) []reflect.Value {
+		if class, ok := args[0].Interface().(*LuaTable); ok && class != nil {
+			class.Release()
+		}
+		if ft.IsVariadic() {
+			return factory.CallSlice(args[1:])
+		}
+		return factory.Call(args[1:])
+	})
+}
@@ -0,0 +1,96 @@
+package lua
+
+import (
+	"testing"
+)
+
+type testPoint struct {
+	X, Y int
+}
+
+func (p *testPoint) Move(dx, dy int) {
+	p.X += dx
+	p.Y += dy
+}
+
+func (p *testPoint) Sum() int {
+	return p.X + p.Y
+}
+
+func TestRegisterType(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.RegisterType("Point", func(x, y int) *testPoint {
+		return &testPoint{x, y}
+	}, map[string]interface{}{
+		"origin": func() *testPoint { return &testPoint{} },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.LoadScript(`
+		local p = Point.new{x = 1, y = 2}
+		p:move(1, 1)
+		assert(p.x == 2 and p.y == 3 and p:sum() == 5)
+		local q = Point(3, 4)
+		assert(q:sum() == 7)
+		assert(Point.origin():sum() == 0)
+		assert(not pcall(Point.new, {x = "a"}))
+		local ok, err = pcall(Point.new, {x = 1, z = 1})
+		assert(not ok and string.find(err, "Point.z: field not found", 1, true), err)
+		last = q
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last, err := ctx.GetGlobal("last")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := last.(*testPoint); !ok || p.X != 3 || p.Y != 4 {
+		t.Fatalf("unexpected point %#v", last)
+	}
+}
+
+func TestRegisterTypeErrors(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, prototype := range []interface{}{nil, 1, func() {}, func() int { return 0 }} {
+		if err = ctx.RegisterType("T", prototype); err == nil {
+			t.Fatalf("%T: error expected", prototype)
+		}
+	}
+	if err = ctx.RegisterType("Point", testPoint{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = ctx.LoadScript(`assert(Point{x = 1}.x == 1)`, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type testServer struct {
+	Host string `lua:"hostname"`
+	Port int
+}
+
+func TestRegisterTypeDecode(t *testing.T) {
+	ctx, err := NewContext(WithUnknownFields(UnknownFieldIgnore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ctx.RegisterType("Server", testServer{}); err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.LoadScript(`
+		local s = Server.new{hostname = "localhost", port = 80, debug = true}
+		assert(s.hostname == "localhost" and s.port == 80)
+		assert(not pcall(Server.new, {port = 1.5}))
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
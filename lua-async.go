@@ -0,0 +1,146 @@
+package lua
+
+// #include <stdlib.h>
+// #include "lua.h"
+// #include "lauxlib.h"
+// static void popN(lua_State *L, int n);
+// extern int go_async_result_wrap(lua_State *ctx);
+import "C"
+import (
+	"reflect"
+	"unsafe"
+	"fmt"
+)
+
+// AsyncFunc is a Go function running in a goroutine when it is called by Lua.
+// see Async()
+type AsyncFunc struct {
+	fn reflect.Value
+}
+
+// Async makes a Go function async. When it is called in a coroutine driven by
+// LuaThread.Resume(), the coroutine yields while the function is running in a
+// goroutine, and continues with the result when the function returns, so scripts
+// can be written in straight-line code over slow I/O. Out of such a coroutine,
+// it is called synchronously.
+//
+//   ctx.LoadScript(script, map[string]interface{}{"fetch": lua.Async(fetch)})
+func Async(fn interface{}) *AsyncFunc {
+	fnVal := reflect.ValueOf(fn)
+	if fnVal.Kind() != reflect.Func {
+		return nil
+	}
+	return &AsyncFunc{fn: fnVal}
+}
+
+type asyncResult struct {
+	res interface{}
+	err error
+}
+
+// the async function is a Lua function receiving the result from a channel, which
+// yields the coroutine driven by LuaThread.Resume(). out of such a coroutine, the
+// Go function is called synchronously. see go-chan.go
+const asyncHelper = `
+local result, driven = ...
+local select, running = chan.select, coroutine.running
+return function(start, fn)
+	return function(...)
+		if not driven[running()] then
+			return fn(...)
+		end
+		local _, r = select{{start(...), "recv"}}
+		return result(r)
+	end
+end
+`
+
+var asyncMakerName = "luago.async\x00"
+
+func registerAsyncHelper(ctx *C.lua_State) {
+	cstr := C.CString(asyncHelper)
+	defer C.free(unsafe.Pointer(cstr))
+	if C.luaL_loadstring(ctx, cstr) != 0 {
+		C.popN(ctx, 1) // [ ]
+		return
+	}
+	// [ chunk ]
+	var name *C.char
+	C.lua_pushcclosure(ctx, (C.lua_CFunction)(C.go_async_result_wrap), 0) // [ chunk result ]
+	getStrPtr(&drivenThreadsName, &name)
+	C.lua_getfield(ctx, C.LUA_REGISTRYINDEX, name) // [ chunk result driven ]
+	if C.lua_pcallk(ctx, 2, 1, 0, 0, nil) != 0 {
+		C.popN(ctx, 1) // [ ]
+		return
+	}
+	// [ maker ]
+	getStrPtr(&asyncMakerName, &name)
+	C.lua_setfield(ctx, C.LUA_REGISTRYINDEX, name) // [ ] with registry[name] = maker
+}
+
+func pushAsyncFunc(ctx *C.lua_State, f *AsyncFunc) {
+	if f == nil {
+		C.lua_pushnil(ctx)
+		return
+	}
+	var name *C.char
+	getStrPtr(&asyncMakerName, &name)
+	C.lua_getfield(ctx, C.LUA_REGISTRYINDEX, name) // [ maker ]
+	pushValueWithMetatable(ctx, f.starter(), goFuncMeta) // [ maker start ]
+	pushValueWithMetatable(ctx, f.fn.Interface(), goFuncMeta) // [ maker start fn ]
+	C.lua_callk(ctx, 2, 1, 0, nil) // [ async-function ]
+}
+
+// starter returns a Go function with the same args as f, which calls f in a goroutine
+// and returns a channel of the result.
+func (f *AsyncFunc) starter() interface{} {
+	fnType := f.fn.Type()
+	in := make([]reflect.Type, fnType.NumIn())
+	for i := range in {
+		in[i] = fnType.In(i)
+	}
+	resultChan := reflect.TypeOf((<-chan *asyncResult)(nil))
+	startType := reflect.FuncOf(in, []reflect.Type{resultChan}, fnType.IsVariadic())
+
+	return reflect.MakeFunc(startType, func(args []reflect.Value) []reflect.Value {
+		ch := make(chan *asyncResult, 1)
+		go func() {
+			r := &asyncResult{}
+			defer func() {
+				if p := recover(); p != nil {
+					r.err = fmt.Errorf("panic in go function: %v", p)
+				}
+				ch <- r
+			}()
+			var out []reflect.Value
+			if fnType.IsVariadic() {
+				out = f.fn.CallSlice(args)
+			} else {
+				out = f.fn.Call(args)
+			}
+			r.res, r.err = goFuncResults(fnType, out)
+		}()
+		return []reflect.Value{reflect.ValueOf((<-chan *asyncResult)(ch))}
+	}).Interface()
+}
+
+//export go_async_result
+func go_async_result(ctx *C.lua_State) C.int {
+	// [ 1 ] result
+	v, ok := getTargetValue(ctx, 1)
+	if !ok {
+		return luaError(ctx, "async result expected")
+	}
+	r, ok := v.(*asyncResult)
+	if !ok {
+		return luaError(ctx, "async result expected")
+	}
+	if r.err != nil {
+		return luaError(ctx, r.err.Error())
+	}
+	if r.res == nil {
+		return 0
+	}
+	pushLuaMetaValue(ctx, r.res)
+	return 1
+}
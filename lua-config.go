@@ -0,0 +1,356 @@
+package lua
+
+// #include <stdlib.h>
+// #include "lua.h"
+// #include "lauxlib.h"
+// static void popN(lua_State *L, int n);
+// static void pushGlobal(lua_State *L);
+// extern int go_config_include_wrap(lua_State *ctx);
+// extern int go_obj_free_wrap(lua_State *ctx);
+import "C"
+import (
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+	"fmt"
+)
+
+// LoadConfig runs the Lua config file in a context with WithSafeLibs(), and decodes
+// the table returned by the file, or the globals if no table is returned, to the struct
+// pointed by out. opts are the options of the context, such as WithModules().
+//
+// The fields of out are decoded as values assigned to Go fields, with the tags:
+//
+//   lua:"name" the name of the field in Lua
+//   lua:",required" the field must be set
+//   default:"value" the value of the field if not set, for bools, numbers, strings and time.Duration
+//
+// A time.Duration can also be set by a string such as "1m30s".
+//
+// Fields of struct types, and the elements of slices of structs, are decoded with these
+// tags too, and a struct field not set gets its defaults.
+//
+// The config can include other files by `include(path)`, which runs the file in the
+// same globals and returns its results, a relative path is relative to the directory
+// of the file including it. Only the files in the directory of the config file, or in
+// its subdirectories, can be included, and the files are loaded as text only.
+func LoadConfig(path string, out interface{}, opts ...Option) (err error) {
+	v := reflect.ValueOf(out)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		err = fmt.Errorf("pointer to struct expected")
+		return
+	}
+
+	absPath, e := filepath.Abs(path)
+	if e == nil {
+		absPath, e = filepath.EvalSymlinks(absPath)
+	}
+	if e != nil {
+		err = fmt.Errorf("failed to load config: %w", e)
+		return
+	}
+
+	ctx, e := NewContext(append([]Option{WithSafeLibs()}, opts...)...)
+	if e != nil {
+		err = e
+		return
+	}
+	// the context is not used after loading, so it is closed without waiting for the GC
+	defer func() {
+		runtime.SetFinalizer(ctx, nil)
+		ctx.release()
+	}()
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	c := ctx.c
+	loader := &configLoader{root: filepath.Dir(absPath)}
+	registerMetatable(c, goConfigMeta, &metaMethod{
+		name: __gc, method: (C.lua_CFunction)(C.go_obj_free_wrap),
+	})
+	pushValueWithMetatable(c, loader, goConfigMeta) // [ loader ]
+	C.lua_pushcclosure(c, (C.lua_CFunction)(C.go_config_include_wrap), 1) // [ include ] with loader as upvalue
+	include := "include\x00"
+	var name *C.char
+	getStrPtr(&include, &name)
+	C.lua_setglobal(c, name) // [ ]
+
+	loader.files = append(loader.files, absPath)
+	cstr := C.CString(absPath)
+	defer C.free(unsafe.Pointer(cstr))
+	if C.luaL_loadfilex(c, cstr, textMode()) != C.LUA_OK || protectedCall(c, 0, 1) != C.LUA_OK {
+		err = fmt.Errorf("failed to load config: %w", popLuaError(c))
+		return
+	}
+	// [ result ]
+	if C.lua_type(c, -1) != C.LUA_TTABLE {
+		C.popN(c, 1) // [ ]
+		C.pushGlobal(c) // [ global ]
+	}
+	defer C.popN(c, 1) // [ ]
+
+	d := &configDecoder{valueDecoder{visiting: make(map[unsafe.Pointer]bool), unknownFields: UnknownFieldIgnore}}
+	return d.decodeStruct(c, v.Elem(), "")
+}
+
+var (
+	goConfigMeta = "goConfigMeta\x00"
+	configMode = "t\x00"
+)
+
+// textMode is the mode of luaL_loadfilex() refusing precompiled chunks.
+func textMode() (mode *C.char) {
+	getStrPtr(&configMode, &mode)
+	return
+}
+
+// configLoader is the upvalue of `include`.
+type configLoader struct {
+	root string // the directory of the config file, with symbolic links resolved
+	files []string // the files being loaded, the last one is running
+}
+
+//export go_config_include
+func go_config_include(ctx *C.lua_State) C.int {
+	// [ 1 ] path
+	v, _ := getTargetValue(ctx, C.LUA_REGISTRYINDEX - 1) // upvalue 1
+	loader, ok := v.(*configLoader)
+	if !ok {
+		return luaError(ctx, "config loader not found")
+	}
+	path, ok := getStringArg(ctx, 1)
+	if !ok {
+		return luaError(ctx, "bad argument #1 to 'include' (string expected)")
+	}
+	C.lua_settop(ctx, 1) // [ path ]
+
+	file := path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(loader.files[len(loader.files)-1]), path)
+	}
+	// the links are resolved for both the root check and the cycle check
+	path, e := filepath.EvalSymlinks(filepath.Clean(path))
+	if e != nil {
+		return luaError(ctx, fmt.Sprintf("cannot include %s: %v", file, e))
+	}
+	if path != loader.root && !strings.HasPrefix(path, loader.root + string(filepath.Separator)) {
+		return luaError(ctx, fmt.Sprintf("cannot include %s: out of the config directory", file))
+	}
+	for _, f := range loader.files {
+		if f == path {
+			return luaError(ctx, fmt.Sprintf("include cycle: %s", strings.Join(append(loader.files, path), " -> ")))
+		}
+	}
+
+	cstr := C.CString(path)
+	defer C.free(unsafe.Pointer(cstr))
+	if C.luaL_loadfilex(ctx, cstr, textMode()) != C.LUA_OK {
+		// [ path err ], raised again by the C wrapper
+		return -1
+	}
+	// [ path chunk ]
+	loader.files = append(loader.files, path)
+	rc := C.lua_pcallk(ctx, 0, C.LUA_MULTRET, 0, 0, nil)
+	loader.files = loader.files[:len(loader.files)-1]
+	if rc != C.LUA_OK {
+		// [ path err ], raised again by the C wrapper
+		return -1
+	}
+	// [ path r1 ... rN ]
+	return C.lua_gettop(ctx) - 1
+}
+
+// configDecoder decodes the config with the tags `default` and `required`.
+type configDecoder struct {
+	valueDecoder
+}
+
+func (d *configDecoder) decodeStruct(ctx *C.lua_State, st reflect.Value, path string) (err error) {
+	// [ ... table ]
+	t := st.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("lua"), ",")
+		if name == "-" {
+			continue
+		}
+		fv := st.Field(i)
+		if f.Anonymous && len(name) == 0 && f.Type.Kind() == reflect.Struct {
+			// the fields of an embedded struct are in the same table
+			if err = d.decodeStruct(ctx, fv, path); err != nil {
+				return
+			}
+			continue
+		}
+
+		key := d.getField(ctx, f, name) // [ ... table val ]
+		fieldPath := key
+		if len(path) > 0 {
+			fieldPath = path + "." + key
+		}
+		err = d.decodeField(ctx, f, fv, fieldPath)
+		C.popN(ctx, 1) // [ ... table ]
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// getField pushes the value of field f from the table at the top, the key found is returned.
+func (d *configDecoder) getField(ctx *C.lua_State, f reflect.StructField, name string) string {
+	// [ ... table ]
+	keys := []string{name}
+	if len(name) == 0 {
+		keys = []string{lowerFirst(f.Name), f.Name}
+	}
+	for _, key := range keys {
+		pushString(ctx, key) // [ ... table key ]
+		C.lua_rawget(ctx, -2) // [ ... table val ]
+		if C.lua_type(ctx, -1) != C.LUA_TNIL {
+			return key
+		}
+		C.popN(ctx, 1) // [ ... table ]
+	}
+	C.lua_pushnil(ctx) // [ ... table nil ]
+	return keys[0]
+}
+
+func (d *configDecoder) decodeField(ctx *C.lua_State, f reflect.StructField, fv reflect.Value, path string) (err error) {
+	// [ ... val ]
+	if C.lua_type(ctx, -1) == C.LUA_TNIL {
+		if def, ok := f.Tag.Lookup("default"); ok {
+			return setDefault(fv, def, path)
+		}
+		if hasTagOption(f, "required") {
+			return fmt.Errorf("%s: required", path)
+		}
+		if fv.Kind() == reflect.Struct && fv.Type() != timeType {
+			// the defaults and the required fields of the struct are checked
+			C.lua_createtable(ctx, 0, 0) // [ ... val {} ]
+			err = d.decodeStruct(ctx, fv, path)
+			C.popN(ctx, 1) // [ ... val ]
+		}
+		return
+	}
+
+	if fv.Type() == durationType && C.lua_type(ctx, -1) == C.LUA_TSTRING {
+		s, _ := getStringArg(ctx, -1)
+		dur, e := time.ParseDuration(s)
+		if e != nil {
+			return fmt.Errorf("%s: %v", path, e)
+		}
+		fv.SetInt(int64(dur))
+		return
+	}
+	if C.lua_type(ctx, -1) == C.LUA_TTABLE {
+		switch {
+		case fv.Kind() == reflect.Struct:
+			return d.decodeTable(ctx, fv, path)
+		case fv.Kind() == reflect.Ptr && fv.Type().Elem().Kind() == reflect.Struct:
+			p := reflect.New(fv.Type().Elem())
+			if err = d.decodeTable(ctx, p.Elem(), path); err != nil {
+				return
+			}
+			fv.Set(p)
+			return
+		case fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() == reflect.Struct:
+			return d.decodeStructs(ctx, fv, path)
+		}
+	}
+	return d.decode(ctx, fv, path)
+}
+
+// decodeStructs decodes a list of structs, with the defaults of the elements.
+func (d *configDecoder) decodeStructs(ctx *C.lua_State, fv reflect.Value, path string) (err error) {
+	// [ ... list ]
+	leave, err := d.enter(ctx, path)
+	if err != nil {
+		return
+	}
+	defer leave()
+
+	n := int(C.lua_rawlen(ctx, -1))
+	list := reflect.MakeSlice(fv.Type(), n, n)
+	for i := 0; i < n; i++ {
+		elemPath := fmt.Sprintf("%s[%d]", path, i+1)
+		C.lua_rawgeti(ctx, -1, C.lua_Integer(i+1)) // [ ... list elem ]
+		if C.lua_type(ctx, -1) == C.LUA_TTABLE {
+			err = d.decodeTable(ctx, list.Index(i), elemPath)
+		} else {
+			err = d.decode(ctx, list.Index(i), elemPath)
+		}
+		C.popN(ctx, 1) // [ ... list ]
+		if err != nil {
+			return
+		}
+	}
+	fv.Set(list)
+	return
+}
+
+func (d *configDecoder) decodeTable(ctx *C.lua_State, st reflect.Value, path string) (err error) {
+	// [ ... table ]
+	leave, err := d.enter(ctx, path)
+	if err != nil {
+		return
+	}
+	defer leave()
+	return d.decodeStruct(ctx, st, path)
+}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// setDefault sets the value of tag `default` to fv.
+func setDefault(fv reflect.Value, def string, path string) (err error) {
+	if fv.Type() == durationType {
+		d, e := time.ParseDuration(def)
+		if e != nil {
+			return fmt.Errorf("%s: bad default %q: %v", path, def, e)
+		}
+		fv.SetInt(int64(d))
+		return
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(def)
+	case reflect.Bool:
+		b, e := strconv.ParseBool(def)
+		if e != nil {
+			return fmt.Errorf("%s: bad default %q: %v", path, def, e)
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, e := strconv.ParseInt(def, 0, fv.Type().Bits())
+		if e != nil {
+			return fmt.Errorf("%s: bad default %q: %v", path, def, e)
+		}
+		fv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, e := strconv.ParseUint(def, 0, fv.Type().Bits())
+		if e != nil {
+			return fmt.Errorf("%s: bad default %q: %v", path, def, e)
+		}
+		fv.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, e := strconv.ParseFloat(def, fv.Type().Bits())
+		if e != nil {
+			return fmt.Errorf("%s: bad default %q: %v", path, def, e)
+		}
+		fv.SetFloat(f)
+	default:
+		return fmt.Errorf("%s: default not supported for %s", path, fv.Type())
+	}
+	return
+}
@@ -0,0 +1,136 @@
+package lua
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"fmt"
+)
+
+type testBackend struct {
+	Host string `lua:",required"`
+	Port int `default:"80"`
+}
+
+type testConfig struct {
+	Name string `lua:"name"`
+	Debug bool
+	Timeout time.Duration `default:"5s"`
+	Retry int `default:"3"`
+	Servers []testBackend
+	Limits struct {
+		Max float64 `default:"1.5"`
+	}
+}
+
+func writeConfigFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"main.lua": `
+			local common = include("common.lua")
+			name = common.name
+			debug = true
+			timeout = "1m30s"
+			servers = {
+				{host = "a"},
+				{host = "b", port = 8080},
+			}
+		`,
+		"common.lua": `return {name = "app"}`,
+	})
+	var cfg testConfig
+	if err := LoadConfig(filepath.Join(dir, "main.lua"), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Name != "app" || !cfg.Debug || cfg.Timeout != 90*time.Second || cfg.Retry != 3 || cfg.Limits.Max != 1.5 {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+	if len(cfg.Servers) != 2 || cfg.Servers[0] != (testBackend{"a", 80}) || cfg.Servers[1] != (testBackend{"b", 8080}) {
+		t.Fatalf("unexpected servers: %+v", cfg.Servers)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"required.lua": `servers = {{port = 1}}`,
+		"sandbox.lua": `os.exit(1)`,
+	})
+	// an absolute path is cleaned for the cycle check too
+	cycle := fmt.Sprintf("include(%q)", dir + "/./sub/../cycle.lua")
+	if err := os.WriteFile(filepath.Join(dir, "cycle.lua"), []byte(cycle), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"required.lua": "servers[1].host: required",
+		"sandbox.lua": "attempt to index a nil value",
+		"cycle.lua": "include cycle",
+	} {
+		var cfg testConfig
+		err := LoadConfig(filepath.Join(dir, name), &cfg)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("%s: error %q expected, got %v", name, want, err)
+		}
+	}
+}
+
+func TestLoadConfigConfined(t *testing.T) {
+	outside := writeConfigFiles(t, map[string]string{"secret.lua": `return {name = "secret"}`})
+	dir := filepath.Join(outside, "conf")
+	files := map[string]string{
+		"parent.lua": `include("../secret.lua")`,
+		"absolute.lua": fmt.Sprintf("include(%q)", filepath.Join(outside, "secret.lua")),
+		"link.lua": `include("secret.lua")`,
+		"binary.lua": `include("chunk.luac")`,
+	}
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret.lua"), filepath.Join(dir, "secret.lua")); err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.LoadScript(`
+		local f = assert(io.open(path, "wb"))
+		f:write(string.dump(function() return {name = "binary"} end))
+		f:close()
+	`, map[string]interface{}{"path": filepath.Join(dir, "chunk.luac")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"parent.lua": "out of the config directory",
+		"absolute.lua": "out of the config directory",
+		"link.lua": "out of the config directory",
+		"binary.lua": "attempt to load a binary chunk",
+	} {
+		var cfg testConfig
+		err := LoadConfig(filepath.Join(dir, name), &cfg)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("%s: error %q expected, got %v", name, want, err)
+		}
+	}
+	var cfg testConfig
+	if err = LoadConfig(filepath.Join(dir, "chunk.luac"), &cfg); err == nil {
+		t.Fatal("binary chunk loaded")
+	}
+}
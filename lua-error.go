@@ -0,0 +1,82 @@
+package lua
+
+// #include "lua.h"
+// #include "lauxlib.h"
+// static void popN(lua_State *L, int n);
+// extern int go_msg_handler_wrap(lua_State *ctx);
+// extern int protectedToString(lua_State *L, int idx);
+import "C"
+import (
+	"unsafe"
+)
+
+// LuaError is the error raised by Lua and returned to Go.
+type LuaError struct {
+	Message   string
+	Traceback string // stack traceback where the error was raised, set if WithTraceback() is used
+}
+
+func (e *LuaError) Error() string {
+	if len(e.Traceback) == 0 {
+		return e.Message
+	}
+	return e.Message + "\n" + e.Traceback
+}
+
+// WithTraceback makes the errors raised by Lua carry the stack traceback.
+func WithTraceback() Option {
+	return func(o *options) {
+		o.traceback = true
+	}
+}
+
+// protectedCall calls the function below the nargs args at the top of the stack in
+// protected mode, the stack traceback is kept if an error is raised and WithTraceback()
+// is used. see popLuaError()
+func protectedCall(ctx *C.lua_State, nargs, nresults C.int) C.int {
+	env := getCtxEnv(ctx)
+	if env == nil || !env.opts.traceback {
+		return C.lua_pcallk(ctx, nargs, nresults, 0, 0, nil)
+	}
+
+	// [ function arg1 ... argN ]
+	env.traceback = ""
+	base := C.lua_gettop(ctx) - nargs
+	C.lua_pushcclosure(ctx, (C.lua_CFunction)(C.go_msg_handler_wrap), 0)
+	C.lua_rotate(ctx, base, 1) // [ handler function arg1 ... argN ]
+	status := C.lua_pcallk(ctx, nargs, nresults, base, 0, nil) // [ handler results... ] or [ handler err ]
+	C.lua_rotate(ctx, base, -1)
+	C.popN(ctx, 1) // [ results... ] or [ err ]
+	return status
+}
+
+//export go_msg_handler
+func go_msg_handler(ctx *C.lua_State) C.int {
+	// [ 1 ] err
+	if env := getCtxEnv(ctx); env != nil {
+		C.luaL_traceback(ctx, ctx, nil, 1) // [ err traceback ]
+		env.traceback = C.GoString(C.lua_tolstring(ctx, -1, (*C.ulong)(unsafe.Pointer(nil))))
+		C.popN(ctx, 1) // [ err ]
+	}
+	return 1
+}
+
+// popLuaError converts the error at the top of the stack to *LuaError and pops it.
+func popLuaError(ctx *C.lua_State) error {
+	// [ err ]
+	// __tostring of the error object is respected
+	if C.protectedToString(ctx, -1) != C.LUA_OK {
+		// [ err err2 ], __tostring failed
+		C.popN(ctx, 1) // [ err ]
+		C.lua_pushstring(ctx, C.lua_typename(ctx, C.lua_type(ctx, -1))) // [ err msg ]
+	}
+	// [ err msg ]
+	e := &LuaError{
+		Message: C.GoString(C.lua_tolstring(ctx, -1, (*C.ulong)(unsafe.Pointer(nil)))),
+	}
+	C.popN(ctx, 2) // [ ]
+	if env := getCtxEnv(ctx); env != nil && env.opts.traceback {
+		e.Traceback, env.traceback = env.traceback, ""
+	}
+	return e
+}
@@ -0,0 +1,60 @@
+package lua
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLuaError(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.LoadScript(`error("boom")`, nil)
+	var le *LuaError
+	if !errors.As(err, &le) {
+		t.Fatalf("*LuaError expected, got %v", err)
+	}
+	if !strings.HasSuffix(le.Message, "boom") || len(le.Traceback) > 0 {
+		t.Fatalf("unexpected error: %q %q", le.Message, le.Traceback)
+	}
+}
+
+func TestLuaErrorTostring(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.LoadScript(`error(setmetatable({}, {__tostring=function() return "custom" end}))`, nil)
+	var le *LuaError
+	if !errors.As(err, &le) || le.Message != "custom" {
+		t.Fatalf("custom message expected, got %v", err)
+	}
+
+	// __tostring raising an error falls back to the type name
+	err = ctx.LoadScript(`error(setmetatable({}, {__tostring=function() error("boom") end}))`, nil)
+	if !errors.As(err, &le) || le.Message != "table" {
+		t.Fatalf("type name expected, got %v", err)
+	}
+}
+
+func TestWithTraceback(t *testing.T) {
+	ctx, err := NewContext(WithTraceback())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.LoadScript(`
+		local function f()
+			error("boom")
+		end
+		f()
+	`, nil)
+	var le *LuaError
+	if !errors.As(err, &le) {
+		t.Fatalf("*LuaError expected, got %v", err)
+	}
+	if !strings.Contains(le.Traceback, "stack traceback") {
+		t.Fatalf("traceback expected, got %q", le.Traceback)
+	}
+}
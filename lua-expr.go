@@ -0,0 +1,159 @@
+package lua
+
+// #include <stdlib.h>
+// #include "lua.h"
+// #include "lauxlib.h"
+// static void popN(lua_State *L, int n);
+// static void pushGlobal(lua_State *L);
+import "C"
+import (
+	"reflect"
+	"unsafe"
+	"fmt"
+)
+
+// Expr is a Lua expression compiled by CompileExpr(), which can be evaluated many times.
+type Expr struct {
+	expr string
+	f *LuaFunction // the chunk `return <expr>`
+}
+
+var (
+	exprEnvMeta = "luago.expr.env\x00"
+	emptyChunk = "\x00"
+)
+
+// CompileExpr compiles the Lua expression expr once for evaluating it with Eval().
+func (ctx *LuaContext) CompileExpr(expr string) (e *Expr, err error) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	c := ctx.c
+	chunk := "return " + expr
+	cstr := C.CString(chunk)
+	defer C.free(unsafe.Pointer(cstr))
+	name := C.CString("=expr")
+	defer C.free(unsafe.Pointer(name))
+
+	if C.luaL_loadbufferx(c, cstr, C.size_t(len(chunk)), name, nil) != C.LUA_OK {
+		err = fmt.Errorf("failed to compile expr: %w", popLuaError(c))
+		return
+	}
+	// [ chunk ]
+	f := newLuaFunction(c) // [ chunk ] with registry[f.ref] = chunk
+	C.popN(c, 1) // [ ]
+	return &Expr{expr: expr, f: f}, nil
+}
+
+// Release frees the compiled expression, which can't be evaluated after released.
+func (e *Expr) Release() {
+	e.f.Release()
+}
+
+// String returns the source of the expression.
+func (e *Expr) String() string {
+	return e.expr
+}
+
+// eval evaluates the expression with vars, the result is at the top of the stack.
+// env.mu must be held.
+func (e *Expr) eval(vars map[string]interface{}) (c *C.lua_State, err error) {
+	if c, err = e.f.push(); err != nil { // [ chunk ]
+		return
+	}
+
+	// the environment of an evaluation is the vars, falling back to the globals
+	C.lua_createtable(c, 0, C.int(len(vars))) // [ chunk env ]
+	for k, v := range vars {
+		pushString(c, k) // [ chunk env k ]
+		pushLuaMetaValue(c, v) // [ chunk env k v ]
+		C.lua_rawset(c, -3) // [ chunk env ]
+	}
+	var name *C.char
+	getStrPtr(&exprEnvMeta, &name)
+	if C.luaL_newmetatable(c, name) != 0 {
+		// [ chunk env meta ]
+		C.pushGlobal(c) // [ chunk env meta global ]
+		getStrPtr(&__index, &name)
+		C.lua_setfield(c, -2, name) // [ chunk env meta ] with meta.__index = global
+	}
+	C.lua_setmetatable(c, -2) // [ chunk env ]
+
+	// _ENV of the chunk is shared with the closures created by the former evaluations,
+	// so the chunk is joined to a new upvalue of an empty chunk instead of setting it.
+	getStrPtr(&emptyChunk, &name)
+	if C.luaL_loadbufferx(c, name, 0, name, nil) != C.LUA_OK {
+		// [ chunk env err ]
+		err = fmt.Errorf("failed to eval %s: %w", e.expr, popLuaError(c)) // [ chunk env ]
+		C.popN(c, 2) // [ ]
+		return
+	}
+	// [ chunk env empty ]
+	C.lua_rotate(c, -2, 1) // [ chunk empty env ]
+	C.lua_setupvalue(c, -2, 1) // [ chunk empty ] with _ENV of empty = env
+	C.lua_upvaluejoin(c, -2, 1, -1, 1) // [ chunk empty ] with _ENV of chunk = _ENV of empty
+	C.popN(c, 1) // [ chunk ]
+
+	if protectedCall(c, 0, 1) != C.LUA_OK {
+		// [ err ]
+		err = fmt.Errorf("failed to eval %s: %w", e.expr, popLuaError(c)) // [ ]
+		return
+	}
+	// [ result ]
+	return
+}
+
+// Eval evaluates the expression with vars, which are the variables of this evaluation
+// only, the globals can be used too.
+func (e *Expr) Eval(vars map[string]interface{}) (res interface{}, err error) {
+	e.f.env.mu.Lock()
+	defer e.f.env.mu.Unlock()
+
+	c, err := e.eval(vars)
+	if err != nil {
+		return
+	}
+	// [ result ]
+	results, err := fromLuaValues(c, 1) // [ ]
+	if err != nil {
+		return
+	}
+	res = results[0]
+	return
+}
+
+// evalAs evaluates the expression, and decodes the result to the value pointed by out.
+// A nil result is an error unless nilOK is true.
+func (e *Expr) evalAs(vars map[string]interface{}, out interface{}, nilOK bool) (err error) {
+	e.f.env.mu.Lock()
+	defer e.f.env.mu.Unlock()
+
+	c, err := e.eval(vars)
+	if err != nil {
+		return
+	}
+	// [ result ]
+	defer C.popN(c, 1) // [ ]
+	if !nilOK && C.lua_type(c, -1) == C.LUA_TNIL {
+		return fmt.Errorf("failed to eval %s: result is nil", e.expr)
+	}
+	return decodeLuaValue(c, reflect.ValueOf(out).Elem(), "result")
+}
+
+// EvalBool evaluates the expression, and the result must be a boolean, nil is false.
+func (e *Expr) EvalBool(vars map[string]interface{}) (b bool, err error) {
+	err = e.evalAs(vars, &b, true)
+	return
+}
+
+// EvalFloat evaluates the expression, and the result must be a number.
+func (e *Expr) EvalFloat(vars map[string]interface{}) (f float64, err error) {
+	err = e.evalAs(vars, &f, false)
+	return
+}
+
+// EvalString evaluates the expression, and the result must be a string.
+func (e *Expr) EvalString(vars map[string]interface{}) (s string, err error) {
+	err = e.evalAs(vars, &s, false)
+	return
+}
@@ -0,0 +1,86 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExprEval(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ctx.LoadScript(`base = 10`, nil); err != nil {
+		t.Fatal(err)
+	}
+	e, err := ctx.CompileExpr(`x * 2 + base`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Release()
+	for _, x := range []int{1, 2} {
+		f, err := e.EvalFloat(map[string]interface{}{"x": x})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f != float64(x * 2 + 10) {
+			t.Fatalf("unexpected result %v of x=%d", f, x)
+		}
+	}
+	if _, err = e.EvalFloat(nil); err == nil {
+		t.Fatal("error expected without x")
+	}
+	if _, err = e.EvalString(map[string]interface{}{"x": 1}); err == nil {
+		t.Fatal("error expected for a number as string")
+	}
+	if _, err = ctx.CompileExpr(`x +`); err == nil {
+		t.Fatal("error expected for a bad expr")
+	}
+}
+
+func TestExprNilResult(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := ctx.CompileExpr(`missing`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Release()
+	if _, err = e.EvalFloat(nil); err == nil || !strings.Contains(err.Error(), "result is nil") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = e.EvalString(nil); err == nil || !strings.Contains(err.Error(), "result is nil") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b, err := e.EvalBool(nil); err != nil || b {
+		t.Fatalf("unexpected result: %v %v", b, err)
+	}
+}
+
+func TestExprClosures(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := ctx.CompileExpr(`keep(function() return x end)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Release()
+	var fns []func() int
+	keep := func(f func() int) {
+		fns = append(fns, f)
+	}
+	for _, x := range []int{1, 2} {
+		if _, err = e.Eval(map[string]interface{}{"x": x, "keep": keep}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, f := range fns {
+		if x := f(); x != i+1 {
+			t.Fatalf("closure %d sees x=%d", i, x)
+		}
+	}
+}
@@ -4,9 +4,6 @@ package lua
 // #include "lauxlib.h"
 // static void popN(lua_State *L, int n);
 // static void pushGlobal(lua_State *L);
-// static int pCall(lua_State *L, int nargs, int nresults) {
-//	return lua_pcall(L, nargs, nresults, 0);
-// }
 // // static int gc(lua_State *L, int what) {
 // // return lua_gc(L, what);
 // // }
@@ -14,7 +11,8 @@ import "C"
 import (
 	elutils "github.com/rosbit/go-embedding-utils"
 	"reflect"
-	"unsafe"
+	"runtime"
+	"strings"
 	"fmt"
 )
 
@@ -86,10 +84,10 @@ func callLuaFuncFromGo(ctx *C.lua_State, helper *elutils.EmbeddingFuncHelper, ar
 	}
 	var err error
 	var goVal interface{}
-	if C.pCall(ctx, C.int(argc), C.int(nOut)) != 0 {
+	if protectedCall(ctx, C.int(argc), C.int(nOut)) != 0 {
 		// [ some-obj err ]
-		err = fmt.Errorf("%s", C.GoString(C.lua_tolstring(ctx, -1, (*C.ulong)(unsafe.Pointer(nil)))))
-		C.popN(ctx, 2) // [ ]
+		err = popLuaError(ctx) // [ some-obj ]
+		C.popN(ctx, 1) // [ ]
 		goto OUT
 	}
 
@@ -112,10 +110,10 @@ func callFunc(ctx *C.lua_State, args ...interface{}) (res interface{}, err error
 	}
 	// [ obj function arg1 arg2 ... argN ]
 
-	if C.pCall(ctx, C.int(n), C.LUA_MULTRET) != 0 {
+	if protectedCall(ctx, C.int(n), C.LUA_MULTRET) != 0 {
 		// [ obj err ]
-		err = fmt.Errorf("%s", C.GoString(C.lua_tolstring(ctx, -1, (*C.ulong)(unsafe.Pointer(nil)))))
-		C.popN(ctx, 2) // [ ]
+		err = popLuaError(ctx) // [ obj ]
+		C.popN(ctx, 1) // [ ]
 		return
 	}
 
@@ -126,26 +124,123 @@ func callFunc(ctx *C.lua_State, args ...interface{}) (res interface{}, err error
 	return
 }
 
+// LuaFunction is a handle of a Lua function staying in the Lua state. A Go function
+// receives a Lua function as a handle if the type of its argument is *LuaFunction.
+type LuaFunction struct {
+	luaRef
+}
+
+var luaFunctionType = reflect.TypeOf((*LuaFunction)(nil))
+
+// called with a function at the top of the stack
+func newLuaFunction(ctx *C.lua_State) *LuaFunction {
+	f := &LuaFunction{}
+	f.init(ctx)
+	runtime.SetFinalizer(f, func(f *LuaFunction) {
+		go f.Release() // the context may be locked by others
+	})
+	return f
+}
+
+// Release frees the reference of the function. The handle and the Go funcs bound
+// with it can't be used after released.
+func (f *LuaFunction) Release() {
+	if f.unref() {
+		runtime.SetFinalizer(f, nil)
+	}
+}
+
+// Call calls the Lua function with args, all the results are returned.
+func (f *LuaFunction) Call(args ...interface{}) (res []interface{}, err error) {
+	f.env.mu.Lock()
+	defer f.env.mu.Unlock()
+
+	c, e := f.push() // [ function ]
+	if e != nil {
+		err = e
+		return
+	}
+	base := int(C.lua_gettop(c)) - 1
+
+	for _, arg := range args {
+		pushLuaMetaValue(c, arg)
+	}
+	// [ function arg1 arg2 ... argN ]
+
+	if protectedCall(c, C.int(len(args)), C.LUA_MULTRET) != 0 {
+		// [ err ]
+		err = popLuaError(c) // [ ]
+		return
+	}
+
+	// [ o1 o2 ... oN ]
+	return fromLuaValues(c, int(C.lua_gettop(c)) - base)
+}
+
+// fromLuaValues converts the n values at the top of the stack and pops them.
+func fromLuaValues(ctx *C.lua_State, n int) (res []interface{}, err error) {
+	// [ ... o1 o2 ... oN ]
+	defer C.popN(ctx, C.int(n)) // [ ... ]
+
+	base := int(C.lua_gettop(ctx)) - n
+	res = make([]interface{}, n)
+	for i:=0; i<n; i++ {
+		C.lua_pushvalue(ctx, C.int(base + i + 1)) // [ ... o1 o2 ... oN oI ]
+		val, e := fromLuaValue(ctx)
+		C.popN(ctx, 1) // [ ... o1 o2 ... oN ]
+		if e != nil {
+			err = e
+			return
+		}
+		if s, ok := val.(string); ok {
+			val = strings.Clone(s)
+		}
+		res[i] = val
+	}
+	return
+}
+
+// Bind binds a var of golang func with the Lua function, so calling the Lua function
+// is just calling the related golang func.
+// @param fnVarPtr  in format `var funcVar func(....) ...; fnVarPtr = &funcVar`
+func (f *LuaFunction) Bind(fnVarPtr interface{}) (err error) {
+	helper, e := elutils.NewEmbeddingFuncHelper(fnVarPtr)
+	if e != nil {
+		err = e
+		return
+	}
+	helper.BindEmbeddingFunc(f.wrap(helper))
+	return
+}
+
+func (f *LuaFunction) wrap(helper *elutils.EmbeddingFuncHelper) elutils.FnGoFunc {
+	return func(args []reflect.Value) (results []reflect.Value) {
+		f.env.mu.Lock()
+		defer f.env.mu.Unlock()
+
+		// reload the function when calling go-function
+		if f.env.closed || f.ref == C.LUA_NOREF {
+			return helper.ToGolangResults(nil, false, fmt.Errorf("lua function released"))
+		}
+		c := f.env.c
+		C.lua_pushnil(c) // [ nil ] used as a placeholder
+		C.lua_rawgeti(c, C.LUA_REGISTRYINDEX, C.lua_Integer(f.ref)) // [ nil function ]
+
+		return callLuaFuncFromGo(c, helper, args)
+	}
+}
+
 // called by value.go::fromLuaValue()
 func fromLuaFunc(ctx *C.lua_State) (bindGoFunc elutils.FnBindGoFunc) {
 	// [ function ]
-	C.lua_pushnil(ctx) // [ funciton nil ]
-	C.lua_copy(ctx, -2, -1) // [ function function-duplicated ]
-	idx := C.luaL_ref(ctx, C.LUA_REGISTRYINDEX) // [ function ] with registry[idx] = function
+	f := newLuaFunction(ctx) // [ function ] with registry[f.ref] = function
 
 	bindGoFunc = func(fnVarPtr interface{}) elutils.FnGoFunc {
 		helper, e := elutils.NewEmbeddingFuncHelper(fnVarPtr)
 		if e != nil {
 			return nil
 		}
-
-		return func(args []reflect.Value) (results []reflect.Value) {
-			// reload the function when calling go-function
-			C.lua_pushnil(ctx) // [ nil ] used as a placeholder
-			C.lua_rawgeti(ctx, C.LUA_REGISTRYINDEX, C.lua_Integer(idx)) // [ nil function ]
-
-			return callLuaFuncFromGo(ctx, helper, args)
-		}
+		return f.wrap(helper)
 	}
 
 	return bindGoFunc
@@ -0,0 +1,79 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLuaFunction(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fn *LuaFunction
+	err = ctx.LoadScript(`
+		local n = 0
+		keep(function(a, b)
+			n = n + 1
+			if a == nil then
+				error("no args")
+			end
+			return a + b, n
+		end)
+	`, map[string]interface{}{
+		"keep": func(f *LuaFunction) { fn = f },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fn.Release()
+
+	res, err := fn.Call(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 || res[0] != 3.0 || res[1] != 1.0 {
+		t.Fatalf("unexpected results %v", res)
+	}
+	if _, err = fn.Call(); err == nil || !strings.Contains(err.Error(), "no args") {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	var add func(int, int) (int, int)
+	if err = fn.Bind(&add); err != nil {
+		t.Fatal(err)
+	}
+	if sum, n := add(2, 3); sum != 5 || n != 3 {
+		t.Fatalf("unexpected results %d %d", sum, n)
+	}
+
+	// the handle passed back to Lua is the same function
+	if err = ctx.LoadScript(`assert(select(2, f(0, 0)) == 4)`, map[string]interface{}{"f": fn}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLuaFunctionReleased(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fn *LuaFunction
+	err = ctx.LoadScript(`keep(function() end)`, map[string]interface{}{
+		"keep": func(f *LuaFunction) { fn = f },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var call func() error
+	if err = fn.Bind(&call); err != nil {
+		t.Fatal(err)
+	}
+	fn.Release()
+	if _, err = fn.Call(); err == nil {
+		t.Fatal("error expected after released")
+	}
+	if err = call(); err == nil {
+		t.Fatal("error expected calling the bound func after released")
+	}
+}
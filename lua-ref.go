@@ -0,0 +1,65 @@
+package lua
+
+// #include "lua.h"
+// #include "lauxlib.h"
+import "C"
+import (
+	"fmt"
+)
+
+// luaRef keeps a Lua value in the registry of the owning context,
+// it is shared by the handles of Lua values.
+type luaRef struct {
+	env *ctxEnv
+	ref C.int
+}
+
+// called with the Lua value to be referenced at the top of the stack
+func (r *luaRef) init(ctx *C.lua_State) {
+	// [ ... value ]
+	r.env = getCtxEnv(ctx)
+	r.env.retain()
+	C.lua_pushvalue(ctx, -1) // [ ... value value ]
+	r.ref = C.luaL_ref(ctx, C.LUA_REGISTRYINDEX) // [ ... value ] with registry[ref] = value
+}
+
+// push the referenced value to the stack of the owning context. env.mu must be held.
+func (r *luaRef) push() (c *C.lua_State, err error) {
+	if r.env.closed || r.ref == C.LUA_NOREF {
+		err = fmt.Errorf("lua value released")
+		return
+	}
+	c = r.env.c
+	C.lua_rawgeti(c, C.LUA_REGISTRYINDEX, C.lua_Integer(r.ref)) // [ value ]
+	return
+}
+
+// push the referenced value to the stack of ctx, nil is pushed if it is not a value of ctx.
+func (r *luaRef) pushTo(ctx *C.lua_State) {
+	if r.env.closed || r.ref == C.LUA_NOREF || r.env != getCtxEnv(ctx) {
+		C.lua_pushnil(ctx)
+		return
+	}
+	C.lua_rawgeti(ctx, C.LUA_REGISTRYINDEX, C.lua_Integer(r.ref)) // [ value ]
+}
+
+// free the registry slot. returns false if it was released before.
+func (r *luaRef) unref() bool {
+	r.env.mu.Lock()
+	defer r.env.mu.Unlock()
+	return r.unrefLocked()
+}
+
+// unrefLocked is unref() with env.mu held.
+func (r *luaRef) unrefLocked() bool {
+	env := r.env
+	if r.ref == C.LUA_NOREF {
+		return false
+	}
+	if !env.closed {
+		C.luaL_unref(env.c, C.LUA_REGISTRYINDEX, r.ref)
+	}
+	r.ref = C.LUA_NOREF
+	env.releaseLocked()
+	return true
+}
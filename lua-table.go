@@ -0,0 +1,267 @@
+package lua
+
+// #include "lua.h"
+// static void popN(lua_State *L, int n);
+// static int getTable(lua_State *L) {
+//	lua_gettable(L, 1);
+//	return 1;
+// }
+// static int setTable(lua_State *L) {
+//	lua_settable(L, 1);
+//	return 0;
+// }
+// static int pGetTable(lua_State *L) {
+//	// [ table key ] -> [ value ] or [ err ]
+//	lua_pushcfunction(L, getTable);
+//	lua_insert(L, -3);
+//	return lua_pcall(L, 2, 1, 0);
+// }
+// static int pSetTable(lua_State *L) {
+//	// [ table key value ] -> [ ] or [ err ]
+//	lua_pushcfunction(L, setTable);
+//	lua_insert(L, -4);
+//	return lua_pcall(L, 3, 0, 0);
+// }
+import "C"
+import (
+	"reflect"
+	"runtime"
+	"strings"
+	"unsafe"
+	"fmt"
+)
+
+// LuaTable is a handle of a Lua table staying in the Lua state, so the table is
+// neither copied nor detached from its metatable. A Go function receives a table
+// by reference if the type of its argument is *LuaTable.
+type LuaTable struct {
+	luaRef
+}
+
+var luaTableType = reflect.TypeOf((*LuaTable)(nil))
+
+// called with a table at the top of the stack
+func newLuaTable(ctx *C.lua_State) *LuaTable {
+	t := &LuaTable{}
+	t.init(ctx)
+	runtime.SetFinalizer(t, func(t *LuaTable) {
+		go t.Release() // the context may be locked by others
+	})
+	return t
+}
+
+// Release frees the reference of the table. The handle can't be used after released.
+func (t *LuaTable) Release() {
+	if t.unref() {
+		runtime.SetFinalizer(t, nil)
+	}
+}
+
+// Get returns t[key] with metamethod __index respected. Tables are returned as *LuaTable.
+func (t *LuaTable) Get(key interface{}) (val interface{}, err error) {
+	t.env.mu.Lock()
+	defer t.env.mu.Unlock()
+
+	c, e := t.push() // [ table ]
+	if e != nil {
+		err = e
+		return
+	}
+	pushLuaMetaValue(c, key) // [ table key ]
+	if C.pGetTable(c) != 0 {
+		// [ err ]
+		err = fmt.Errorf("%s", C.GoString(C.lua_tolstring(c, -1, (*C.ulong)(unsafe.Pointer(nil)))))
+		C.popN(c, 1) // [ ]
+		return
+	}
+	// [ value ]
+	val, err = fromLuaValueRef(c)
+	C.popN(c, 1) // [ ]
+	return
+}
+
+// Set makes t[key] = val with metamethod __newindex respected.
+func (t *LuaTable) Set(key interface{}, val interface{}) (err error) {
+	t.env.mu.Lock()
+	defer t.env.mu.Unlock()
+
+	c, e := t.push() // [ table ]
+	if e != nil {
+		return e
+	}
+	pushLuaMetaValue(c, key) // [ table key ]
+	pushLuaMetaValue(c, val) // [ table key val ]
+	if C.pSetTable(c) != 0 {
+		// [ err ]
+		err = fmt.Errorf("%s", C.GoString(C.lua_tolstring(c, -1, (*C.ulong)(unsafe.Pointer(nil)))))
+		C.popN(c, 1) // [ ]
+	}
+	return
+}
+
+// Len returns the raw length of the table, just like `#t` without metamethod __len.
+func (t *LuaTable) Len() int {
+	t.env.mu.Lock()
+	defer t.env.mu.Unlock()
+
+	c, e := t.push() // [ table ]
+	if e != nil {
+		return 0
+	}
+	defer C.popN(c, 1) // [ ]
+	return int(C.lua_rawlen(c, -1))
+}
+
+// Append appends values to the array part of the table.
+func (t *LuaTable) Append(vals ...interface{}) (err error) {
+	t.env.mu.Lock()
+	defer t.env.mu.Unlock()
+
+	c, e := t.push() // [ table ]
+	if e != nil {
+		return e
+	}
+	defer C.popN(c, 1) // [ ]
+
+	n := C.lua_rawlen(c, -1)
+	for i, val := range vals {
+		pushLuaMetaValue(c, val) // [ table val ]
+		C.lua_rawseti(c, -2, C.lua_Integer(n) + C.lua_Integer(i) + 1) // [ table ] with table[n+i+1] = val
+	}
+	return
+}
+
+// ForEach iterates all the key/value pairs of the table until fn returns false.
+// Tables in keys or values are passed as *LuaTable. Adding new keys to the table
+// in fn is not allowed.
+func (t *LuaTable) ForEach(fn func(key, val interface{}) bool) (err error) {
+	t.env.mu.Lock()
+	defer t.env.mu.Unlock()
+
+	c, e := t.push() // [ table ]
+	if e != nil {
+		return e
+	}
+	defer C.popN(c, 1) // [ ]
+
+	var key, val interface{}
+	C.lua_pushnil(c) // [ table nil ]
+	for C.lua_next(c, -2) != 0 {
+		// [ table key val ]
+		if val, err = fromLuaValueRef(c); err != nil {
+			C.popN(c, 2) // [ table ]
+			return
+		}
+		C.popN(c, 1) // [ table key ]
+		if key, err = fromLuaValueRef(c); err != nil {
+			C.popN(c, 1) // [ table ]
+			return
+		}
+		if !fn(key, val) {
+			C.popN(c, 1) // [ table ]
+			return
+		}
+	}
+	return
+}
+
+// Metatable returns the metatable of the table, nil if it has none.
+func (t *LuaTable) Metatable() (mt *LuaTable, err error) {
+	t.env.mu.Lock()
+	defer t.env.mu.Unlock()
+
+	c, e := t.push() // [ table ]
+	if e != nil {
+		err = e
+		return
+	}
+	if C.lua_getmetatable(c, -1) == 0 {
+		C.popN(c, 1) // [ ]
+		return
+	}
+	// [ table metatable ]
+	mt = newLuaTable(c)
+	C.popN(c, 2) // [ ]
+	return
+}
+
+// ToMap copies the table to a Go map, integer keys are converted to strings.
+func (t *LuaTable) ToMap() (m map[string]interface{}, err error) {
+	t.env.mu.Lock()
+	defer t.env.mu.Unlock()
+
+	c, e := t.push() // [ table ]
+	if e != nil {
+		err = e
+		return
+	}
+	defer C.popN(c, 1) // [ ]
+
+	m = make(map[string]interface{})
+	var val interface{}
+	var key string
+	C.lua_pushnil(c) // [ table nil ]
+	for C.lua_next(c, -2) != 0 {
+		// [ table key val ]
+		if val, err = fromLuaValue(c); err != nil {
+			C.popN(c, 2) // [ table ]
+			return
+		}
+		if s, ok := val.(string); ok {
+			val = strings.Clone(s)
+		}
+		C.popN(c, 1) // [ table key ]
+		if key, err = getStringKey(c); err != nil {
+			C.popN(c, 1) // [ table ]
+			return
+		}
+		m[strings.Clone(key)] = val
+	}
+	return
+}
+
+// ToSlice copies the array part of the table to a Go slice.
+func (t *LuaTable) ToSlice() (arr []interface{}, err error) {
+	t.env.mu.Lock()
+	defer t.env.mu.Unlock()
+
+	c, e := t.push() // [ table ]
+	if e != nil {
+		err = e
+		return
+	}
+	defer C.popN(c, 1) // [ ]
+
+	n := int(C.lua_rawlen(c, -1))
+	arr = make([]interface{}, n)
+	for i:=0; i<n; i++ {
+		C.lua_rawgeti(c, -1, C.lua_Integer(i+1)) // [ table val ]
+		val, e := fromLuaValue(c)
+		C.popN(c, 1) // [ table ]
+		if e != nil {
+			err = e
+			return
+		}
+		if s, ok := val.(string); ok {
+			val = strings.Clone(s)
+		}
+		arr[i] = val
+	}
+	return
+}
+
+// fromLuaValueRef converts the value at the top of the stack like fromLuaValue,
+// but keeps tables in the Lua state.
+func fromLuaValueRef(ctx *C.lua_State) (goVal interface{}, err error) {
+	if C.lua_type(ctx, -1) == C.LUA_TTABLE {
+		goVal = newLuaTable(ctx)
+		return
+	}
+	if goVal, err = fromLuaValue(ctx); err != nil {
+		return
+	}
+	if s, ok := goVal.(string); ok {
+		goVal = strings.Clone(s)
+	}
+	return
+}
@@ -0,0 +1,126 @@
+package lua
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLuaTable(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tbl *LuaTable
+	err = ctx.LoadScript(`
+		local t = setmetatable({1, 2, name = "a", sub = {x = 1}}, {__index = function(_, k) return "default " .. k end})
+		keep(t)
+		assert(t.added == 3)
+	`, map[string]interface{}{
+		"keep": func(t *LuaTable) {
+			tbl = t
+			tbl.Set("added", 3)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tbl.Release()
+
+	if v, _ := tbl.Get("name"); v != "a" {
+		t.Fatalf("unexpected name %v", v)
+	}
+	if v, _ := tbl.Get("other"); v != "default other" {
+		t.Fatalf("__index not respected, got %v", v)
+	}
+	sub, _ := tbl.Get("sub")
+	if st, ok := sub.(*LuaTable); !ok {
+		t.Fatalf("*LuaTable expected, got %T", sub)
+	} else if x, _ := st.Get("x"); x != 1.0 {
+		t.Fatalf("unexpected sub.x %v", x)
+	}
+
+	if err = tbl.Append(3, 4); err != nil {
+		t.Fatal(err)
+	}
+	if n := tbl.Len(); n != 4 {
+		t.Fatalf("unexpected length %d", n)
+	}
+	arr, err := tbl.ToSlice()
+	if err != nil || len(arr) != 4 || arr[3] != 4.0 {
+		t.Fatalf("unexpected slice %v %v", arr, err)
+	}
+	m, err := tbl.ToMap()
+	if err != nil || m["1"] != 1.0 || m["name"] != "a" || m["added"] != 3.0 {
+		t.Fatalf("unexpected map %v %v", m, err)
+	}
+
+	n := 0
+	if err = tbl.ForEach(func(key, val interface{}) bool { n++; return true }); err != nil {
+		t.Fatal(err)
+	}
+	if n != 7 {
+		t.Fatalf("%d pairs iterated", n)
+	}
+	if mt, err := tbl.Metatable(); err != nil || mt == nil {
+		t.Fatalf("metatable expected, got %v", err)
+	}
+
+	// the handle passed back to Lua is the same table
+	if err = ctx.LoadScript(`assert(t.name == "a" and #t == 4)`, map[string]interface{}{"t": tbl}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLuaTableReleased(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tbl *LuaTable
+	err = ctx.LoadScript(`keep({})`, map[string]interface{}{
+		"keep": func(t *LuaTable) { tbl = t },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl.Release()
+	tbl.Release()
+	if err = tbl.Set("a", 1); err == nil {
+		t.Fatal("error expected after released")
+	}
+}
+
+func TestLuaTableLocked(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	err = ctx.LoadScript(`
+		t = {}
+		keep(t)
+		assert(t.inner == 1 and t.other == nil)
+	`, map[string]interface{}{
+		"keep": func(tbl *LuaTable) {
+			// the Go function called by Lua can use the handle with the context locked
+			tbl.Set("inner", 1)
+			// but another goroutine must wait until Lua returns
+			go func() {
+				tbl.Set("other", 2)
+				close(done)
+			}()
+			select {
+			case <-done:
+				t.Error("the context is not locked")
+			case <-time.After(50 * time.Millisecond):
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if err = ctx.LoadScript(`assert(t.other == 2)`, nil); err != nil {
+		t.Fatal(err)
+	}
+}
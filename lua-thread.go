@@ -0,0 +1,235 @@
+package lua
+
+// #include "lua.h"
+// #include "lauxlib.h"
+// static void popN(lua_State *L, int n);
+// static void pushGlobal(lua_State *L);
+import "C"
+import (
+	"reflect"
+	"runtime"
+	"unsafe"
+	"fmt"
+)
+
+// LuaThread is a handle of a Lua coroutine driven by Go.
+//
+// When the coroutine waits for a Go channel or an async Go function (see Async()),
+// Resume() waits for it without locking the context, and continues the coroutine
+// with the result, so Resume() returns only when the coroutine yields by itself,
+// returns or fails.
+type LuaThread struct {
+	luaRef
+	thread *C.lua_State
+	nargs int // number of args pushed for the next resume
+	running bool
+	closing bool // Close() is called while running, the thread is closed once Resume() returns
+}
+
+var luaThreadType = reflect.TypeOf((*LuaThread)(nil))
+
+// called with a thread at the top of the stack
+func newLuaThread(ctx *C.lua_State) *LuaThread {
+	t := &LuaThread{thread: C.lua_tothread(ctx, -1)}
+	t.init(ctx)
+	runtime.SetFinalizer(t, func(t *LuaThread) {
+		go t.Close() // the context may be locked by others
+	})
+	return t
+}
+
+// NewThread creates a coroutine running the global function funcName with args.
+// The function starts running when Resume() is called the first time.
+func (ctx *LuaContext) NewThread(funcName string, args ...interface{}) (t *LuaThread, err error) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	c := ctx.c
+	C.pushGlobal(c) // [ global ]
+	if !getVar(c, funcName) { // [ global function ]
+		err = fmt.Errorf("function %s not found", funcName)
+		C.popN(c, 2) // [ ]
+		return
+	}
+	if C.lua_type(c, -1) != C.LUA_TFUNCTION {
+		err = fmt.Errorf("var %s is not with type function", funcName)
+		C.popN(c, 2) // [ ]
+		return
+	}
+
+	L := C.lua_newthread(c) // [ global function thread ]
+	t = newLuaThread(c)
+	C.lua_rotate(c, -2, 1) // [ global thread function ]
+	C.lua_xmove(c, L, 1) // [ global thread ], function moved to the stack of the thread
+	C.popN(c, 2) // [ ]
+
+	for _, arg := range args {
+		pushLuaMetaValue(L, arg)
+	}
+	t.nargs = len(args)
+	return
+}
+
+// Resume starts or continues the coroutine with args, which are the results of
+// `coroutine.yield()` in the coroutine. The values yielded or returned by the
+// coroutine are returned.
+func (t *LuaThread) Resume(args ...interface{}) (res []interface{}, err error) {
+	env := t.env
+	env.mu.Lock()
+	defer env.mu.Unlock()
+
+	if env.closed || t.ref == C.LUA_NOREF || t.closing {
+		err = fmt.Errorf("lua thread released")
+		return
+	}
+	if t.running {
+		err = fmt.Errorf("cannot resume non-suspended coroutine")
+		return
+	}
+	if t.status() == "dead" {
+		err = fmt.Errorf("cannot resume dead coroutine")
+		return
+	}
+
+	L := t.thread
+	for _, arg := range args {
+		pushLuaMetaValue(L, arg)
+	}
+	nargs := t.nargs + len(args)
+	t.nargs = 0
+
+	t.running = true
+	t.setDriven(true)
+	defer func() {
+		t.running = false
+		if env.closed || t.ref == C.LUA_NOREF {
+			return
+		}
+		t.setDriven(false)
+		if t.closing {
+			t.close()
+		}
+	}()
+
+	for {
+		var nres C.int
+		switch C.lua_resume(L, nil, C.int(nargs), &nres) {
+		case C.LUA_OK:
+			return fromLuaValues(L, int(nres))
+		case C.LUA_YIELD:
+			sel, ok := getYieldedSelector(L, int(nres))
+			if !ok {
+				return fromLuaValues(L, int(nres))
+			}
+			C.popN(L, nres)
+
+			// wait for the Go channels without locking the context
+			env.mu.Unlock()
+			chosen, recv, ok, e := sel.wait(getGoContext(L))
+			env.mu.Lock()
+			if env.closed || t.ref == C.LUA_NOREF {
+				err = fmt.Errorf("lua thread released")
+				return
+			}
+			if e != nil {
+				err = e
+				return
+			}
+			nargs = int(sel.pushResult(L, chosen, recv, ok))
+		default:
+			// [ err ], the stack of the dead coroutine is kept for the traceback
+			if env.opts.traceback {
+				C.luaL_traceback(L, L, nil, 0) // [ err traceback ]
+				env.traceback = C.GoString(C.lua_tolstring(L, -1, (*C.ulong)(unsafe.Pointer(nil))))
+				C.popN(L, 1) // [ err ]
+			}
+			// no call can be done on the dead coroutine, so the error is converted in the context
+			C.lua_xmove(L, env.c, 1) // [ ], with err moved to the context
+			err = popLuaError(env.c)
+			return
+		}
+	}
+}
+
+// setDriven marks the coroutine as driven by Resume(), so channel operations and async
+// functions yield it instead of blocking. see go-chan.go
+func (t *LuaThread) setDriven(driven bool) {
+	c := t.env.c
+	var name *C.char
+	getStrPtr(&drivenThreadsName, &name)
+	C.lua_getfield(c, C.LUA_REGISTRYINDEX, name) // [ driven ]
+	C.lua_rawgeti(c, C.LUA_REGISTRYINDEX, C.lua_Integer(t.ref)) // [ driven thread ]
+	if driven {
+		C.lua_pushboolean(c, 1) // [ driven thread true ]
+	} else {
+		C.lua_pushnil(c) // [ driven thread nil ]
+	}
+	C.lua_rawset(c, -3) // [ driven ] with driven[thread] = true or nil
+	C.popN(c, 1) // [ ]
+}
+
+// the coroutine waits for channels if it yields a selector only. see go-chan.go
+func getYieldedSelector(L *C.lua_State, nres int) (sel *chanSelect, ok bool) {
+	if nres != 1 || C.lua_type(L, -1) != C.LUA_TUSERDATA {
+		return
+	}
+	v, o := getTargetValue(L, -1)
+	if !o {
+		return
+	}
+	sel, ok = v.(*chanSelect)
+	return
+}
+
+// Status returns the status of the coroutine, which is one of "suspended",
+// "running" and "dead", just like `coroutine.status()`.
+func (t *LuaThread) Status() string {
+	t.env.mu.Lock()
+	defer t.env.mu.Unlock()
+
+	if t.env.closed || t.ref == C.LUA_NOREF {
+		return "dead"
+	}
+	return t.status()
+}
+
+func (t *LuaThread) status() string {
+	if t.running {
+		return "running"
+	}
+	switch C.lua_status(t.thread) {
+	case C.LUA_YIELD:
+		return "suspended"
+	case C.LUA_OK:
+		if C.lua_gettop(t.thread) == 0 {
+			return "dead"
+		}
+		return "suspended"
+	default:
+		return "dead"
+	}
+}
+
+// Close closes the coroutine and releases the handle. If the coroutine is running,
+// it is closed once Resume() returns.
+func (t *LuaThread) Close() {
+	t.env.mu.Lock()
+	defer t.env.mu.Unlock()
+
+	if t.running {
+		t.closing = true
+		return
+	}
+	t.close()
+}
+
+// close closes the coroutine and releases the handle, env.mu must be held.
+func (t *LuaThread) close() {
+	t.closing = false
+	if !t.env.closed && t.ref != C.LUA_NOREF {
+		C.lua_closethread(t.thread, nil)
+	}
+	if t.unrefLocked() {
+		runtime.SetFinalizer(t, nil)
+	}
+}
@@ -0,0 +1,171 @@
+package lua
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestThreadResume(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.LoadScript(`
+		function gen(n)
+			for i = 1, n do
+				local x = coroutine.yield(i)
+				assert(x == i * 10)
+			end
+			return "done"
+		end
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	th, err := ctx.NewThread("gen", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer th.Close()
+
+	res, err := th.Resume()
+	if err != nil || len(res) != 1 || res[0] != 1.0 {
+		t.Fatalf("unexpected result: %#v %v", res, err)
+	}
+	if s := th.Status(); s != "suspended" {
+		t.Fatalf("unexpected status %s", s)
+	}
+	if res, err = th.Resume(10); err != nil || res[0] != 2.0 {
+		t.Fatalf("unexpected result: %#v %v", res, err)
+	}
+	if res, err = th.Resume(20); err != nil || res[0] != "done" {
+		t.Fatalf("unexpected result: %#v %v", res, err)
+	}
+	if s := th.Status(); s != "dead" {
+		t.Fatalf("unexpected status %s", s)
+	}
+	if _, err = th.Resume(); err == nil {
+		t.Fatal("resuming a dead coroutine should fail")
+	}
+}
+
+func TestThreadError(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.LoadScript(`
+		function fail()
+			error(setmetatable({}, {__tostring=function() error("boom") end}))
+		end
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	th, err := ctx.NewThread("fail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer th.Close()
+	if _, err = th.Resume(); err == nil || err.Error() != "table" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestThreadCloseWhileWaiting(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	started, release := make(chan struct{}), make(chan struct{})
+	err = ctx.LoadScript(`
+		function main()
+			return wait()
+		end
+	`, map[string]interface{}{
+		"wait": Async(func() string {
+			close(started)
+			<-release
+			return "ok"
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	th, err := ctx.NewThread("main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		res, err := th.Resume()
+		if err == nil && (len(res) != 1 || res[0] != "ok") {
+			err = errors.New("unexpected result")
+		}
+		done <- err
+	}()
+	<-started
+
+	// the context is not locked while waiting, but the running coroutine can't be resumed
+	if _, err = th.Resume(); err == nil || !strings.Contains(err.Error(), "non-suspended") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s := th.Status(); s != "running" {
+		t.Fatalf("unexpected status %s", s)
+	}
+	// closing is deferred until Resume() returns
+	th.Close()
+	close(release)
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	if s := th.Status(); s != "dead" {
+		t.Fatalf("unexpected status %s", s)
+	}
+	if _, err = th.Resume(); err == nil {
+		t.Fatal("resuming a closed coroutine should fail")
+	}
+}
+
+func TestAsyncOutOfThread(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.LoadScript(`
+		function main()
+			-- a generator created in Lua yields its own values only
+			local gen = coroutine.wrap(function()
+				coroutine.yield(fill({}).n)
+				coroutine.yield(ch:recv())
+			end)
+			return gen(), (gen())
+		end
+		assert(fill({}).n == 1)
+	`, map[string]interface{}{
+		// the async function out of a driven coroutine runs on the calling goroutine,
+		// so it can use the table with the context locked
+		"fill": Async(func(t *LuaTable) *LuaTable {
+			t.Set("n", 1)
+			return t
+		}),
+		"ch": func() chan int {
+			ch := make(chan int, 1)
+			ch <- 2
+			return ch
+		}(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	th, err := ctx.NewThread("main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer th.Close()
+	if res, err := th.Resume(); err != nil || len(res) != 2 || res[0] != 1.0 || res[1] != 2.0 {
+		t.Fatalf("unexpected result: %#v %v", res, err)
+	}
+}
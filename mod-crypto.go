@@ -0,0 +1,225 @@
+package lua
+
+// #include "lua.h"
+// static void popN(lua_State *L, int n);
+// extern int go_crypto_func_wrap(lua_State *ctx);
+import "C"
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"hash/crc32"
+	"strings"
+	"fmt"
+)
+
+// CryptoModule is the Lua module `crypto` of hashing and encoding, strings are
+// binary in both directions:
+//
+//   crypto.md5(s), crypto.sha1(s), crypto.sha256(s), crypto.sha512(s) return the digest of s
+//   crypto.hmac(algo, key, s) returns the HMAC of s, algo is "md5", "sha1", "sha256" or "sha512"
+//   crypto.crc32(s) returns the IEEE CRC-32 checksum of s as an integer
+//   crypto.hex_encode(s), crypto.hex_decode(s) encode and decode hex
+//   crypto.base64_encode(s), crypto.base64_decode(s) encode and decode the standard base64
+//   crypto.base64url_encode(s), crypto.base64url_decode(s) encode and decode the URL base64
+//     without padding
+//   crypto.random_bytes(n) returns n secure random bytes, n is at most 1MB
+//   crypto.equal(a, b) compares a and b in constant time
+//
+// Digests are binary, such as `crypto.hex_encode(crypto.sha256(s))` for the hex. The
+// base64 decoders accept the input with or without padding.
+var CryptoModule = &Module{name: "crypto", open: openCryptoModule}
+
+// cryptoFunc is a function of the crypto module, it is called by go_crypto_func
+// with the key in cryptoFuncs as the upvalue.
+type cryptoFunc func(ctx *C.lua_State) (n int, err error)
+
+var cryptoFuncs map[string]cryptoFunc
+
+var hashFuncs = map[string]func() hash.Hash{
+	"md5": md5.New,
+	"sha1": sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+func init() {
+	cryptoFuncs = map[string]cryptoFunc{
+		"md5": hashFunc(md5.New),
+		"sha1": hashFunc(sha1.New),
+		"sha256": hashFunc(sha256.New),
+		"sha512": hashFunc(sha512.New),
+		"hmac": cryptoHMAC,
+		"crc32": cryptoCRC32,
+		"hex_encode": encodeFunc(hex.EncodeToString),
+		"hex_decode": decodeFunc(hex.DecodeString),
+		"base64_encode": encodeFunc(base64.StdEncoding.EncodeToString),
+		"base64_decode": decodeFunc(func(s string) ([]byte, error) {
+			return base64.RawStdEncoding.DecodeString(strings.TrimRight(s, "="))
+		}),
+		"base64url_encode": encodeFunc(base64.RawURLEncoding.EncodeToString),
+		"base64url_decode": decodeFunc(func(s string) ([]byte, error) {
+			return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+		}),
+		"random_bytes": cryptoRandomBytes,
+		"equal": cryptoEqual,
+	}
+}
+
+func openCryptoModule(ctx *C.lua_State) {
+	C.lua_createtable(ctx, 0, C.int(len(cryptoFuncs))) // [ crypto ]
+	for name := range cryptoFuncs {
+		pushString(ctx, name) // [ crypto name ]
+		pushString(ctx, name) // [ crypto name name ]
+		C.lua_pushcclosure(ctx, (C.lua_CFunction)(C.go_crypto_func_wrap), 1) // [ crypto name fn ] with name as upvalue
+		C.lua_rawset(ctx, -3) // [ crypto ]
+	}
+}
+
+//export go_crypto_func
+func go_crypto_func(ctx *C.lua_State) C.int {
+	var length C.size_t
+	key := C.lua_tolstring(ctx, C.LUA_REGISTRYINDEX - 1, &length) // upvalue 1
+	fn, ok := cryptoFuncs[C.GoStringN(key, C.int(length))]
+	if !ok {
+		return luaError(ctx, "unknown function of crypto")
+	}
+	n, err := fn(ctx)
+	if err != nil {
+		return luaError(ctx, err.Error())
+	}
+	return C.int(n)
+}
+
+func stringArg(ctx *C.lua_State, idx C.int) (s string, err error) {
+	var ok bool
+	if s, ok = getStringArg(ctx, idx); !ok {
+		err = fmt.Errorf("bad argument #%d (string expected)", idx)
+	}
+	return
+}
+
+func hashFunc(newHash func() hash.Hash) cryptoFunc {
+	return func(ctx *C.lua_State) (n int, err error) {
+		// [ 1 ] s
+		s, e := stringArg(ctx, 1)
+		if e != nil {
+			err = e
+			return
+		}
+		h := newHash()
+		h.Write([]byte(s))
+		pushString(ctx, string(h.Sum(nil)))
+		return 1, nil
+	}
+}
+
+func cryptoHMAC(ctx *C.lua_State) (n int, err error) {
+	// [ 1 ] algo
+	// [ 2 ] key
+	// [ 3 ] s
+	var algo, key, s string
+	if algo, err = stringArg(ctx, 1); err != nil {
+		return
+	}
+	newHash, ok := hashFuncs[strings.ToLower(algo)]
+	if !ok {
+		err = fmt.Errorf("bad argument #1 (unknown hash %s)", algo)
+		return
+	}
+	if key, err = stringArg(ctx, 2); err != nil {
+		return
+	}
+	if s, err = stringArg(ctx, 3); err != nil {
+		return
+	}
+	h := hmac.New(newHash, []byte(key))
+	h.Write([]byte(s))
+	pushString(ctx, string(h.Sum(nil)))
+	return 1, nil
+}
+
+func cryptoCRC32(ctx *C.lua_State) (n int, err error) {
+	// [ 1 ] s
+	s, e := stringArg(ctx, 1)
+	if e != nil {
+		err = e
+		return
+	}
+	C.lua_pushinteger(ctx, C.lua_Integer(crc32.ChecksumIEEE([]byte(s))))
+	return 1, nil
+}
+
+func encodeFunc(encode func([]byte) string) cryptoFunc {
+	return func(ctx *C.lua_State) (n int, err error) {
+		// [ 1 ] s
+		s, e := stringArg(ctx, 1)
+		if e != nil {
+			err = e
+			return
+		}
+		pushString(ctx, encode([]byte(s)))
+		return 1, nil
+	}
+}
+
+func decodeFunc(decode func(string) ([]byte, error)) cryptoFunc {
+	return func(ctx *C.lua_State) (n int, err error) {
+		// [ 1 ] s
+		s, e := stringArg(ctx, 1)
+		if e != nil {
+			err = e
+			return
+		}
+		b, e := decode(s)
+		if e != nil {
+			err = e
+			return
+		}
+		pushString(ctx, string(b))
+		return 1, nil
+	}
+}
+
+// maxRandomBytes is the max n of crypto.random_bytes(n), against scripts exhausting memory.
+const maxRandomBytes = 1 << 20
+
+func cryptoRandomBytes(ctx *C.lua_State) (n int, err error) {
+	// [ 1 ] n
+	size, e := intArg(ctx, 1, -1)
+	if e != nil || size < 0 {
+		err = fmt.Errorf("bad argument #1 (non-negative integer expected)")
+		return
+	}
+	if size > maxRandomBytes {
+		err = fmt.Errorf("bad argument #1 (at most %d bytes expected)", maxRandomBytes)
+		return
+	}
+	b := make([]byte, size)
+	if _, err = rand.Read(b); err != nil {
+		return
+	}
+	pushString(ctx, string(b))
+	return 1, nil
+}
+
+func cryptoEqual(ctx *C.lua_State) (n int, err error) {
+	// [ 1 ] a
+	// [ 2 ] b
+	var a, b string
+	if a, err = stringArg(ctx, 1); err != nil {
+		return
+	}
+	if b, err = stringArg(ctx, 2); err != nil {
+		return
+	}
+	pushBool(ctx, subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1)
+	return 1, nil
+}
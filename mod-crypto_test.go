@@ -0,0 +1,45 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCrypto(t *testing.T) {
+	ctx, err := NewContext(WithModules(CryptoModule))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.LoadScript(`
+		assert(crypto.hex_encode(crypto.md5("")) == "d41d8cd98f00b204e9800998ecf8427e")
+		assert(crypto.hex_encode(crypto.sha256("abc")) == "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad")
+		assert(crypto.hex_decode("616263") == "abc")
+		assert(crypto.base64_encode("ab") == "YWI=")
+		assert(crypto.base64_decode("YWI") == "ab")
+		assert(crypto.base64url_decode(crypto.base64url_encode("\255\254")) == "\255\254")
+		assert(crypto.equal("abc", "abc") and not crypto.equal("abc", "abd"))
+		assert(#crypto.random_bytes(16) == 16 and crypto.random_bytes(0) == "")
+		assert(#crypto.random_bytes(1 << 20) == 1 << 20)
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCryptoRandomBytesLimit(t *testing.T) {
+	ctx, err := NewContext(WithModules(CryptoModule))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, script := range []string{
+		`crypto.random_bytes((1 << 20) + 1)`,
+		`crypto.random_bytes(math.maxinteger)`,
+		`crypto.random_bytes(-1)`,
+		`crypto.random_bytes("x")`,
+	} {
+		err = ctx.LoadScript(script, nil)
+		if err == nil || !strings.Contains(err.Error(), "bad argument #1") {
+			t.Fatalf("%s: unexpected error %v", script, err)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package lua
+
+// #include "lua.h"
+// #include "lauxlib.h"
+// static void popN(lua_State *L, int n);
+// extern int go_fs_func_wrap(lua_State *ctx);
+// extern int go_obj_free_wrap(lua_State *ctx);
+import "C"
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"fmt"
+)
+
+// FSModule returns the Lua module `fs` reading the files of fsys:
+//
+//   fs.read(path) returns the content of a file
+//   fs.list(path) returns the sorted names of the entries of a directory
+//   fs.stat(path) returns a table {name=, size=, is_dir=, mode=, mod_time=}
+//   fs.exists(path) returns true if the file exists
+//   fs.glob(pattern) returns the paths matching pattern, see path.Match for the syntax
+//
+// Paths are slash-separated and relative to the root of fsys, a leading "/" is the
+// root, and a path escaping the root such as "../a" is refused. mod_time is a time
+// of the module `time`.
+func FSModule(fsys fs.FS) *Module {
+	return newFSModule(&luaFS{fsys: fsys})
+}
+
+// DirModule returns the Lua module `fs` of the directory root, which works as
+// FSModule. If writable is true, the module also has functions:
+//
+//   fs.write(path, data) writes data to a file, which is created if not existing
+//   fs.append(path, data) appends data to a file, which is created if not existing
+//   fs.mkdir(path) creates a directory with all the missing parents
+//   fs.remove(path) removes a file or an empty directory
+//
+// Symbolic links pointing out of root are refused too.
+func DirModule(root string, writable bool) *Module {
+	return newFSModule(&luaFS{fsys: os.DirFS(root), root: root, writable: writable})
+}
+
+var goFSMeta = "goFSMeta\x00"
+
+type luaFS struct {
+	fsys fs.FS
+	root string // the directory of DirModule
+	writable bool
+}
+
+// fsFunc is a function of the fs module, it is called by go_fs_func with the
+// luaFS as upvalue 1 and the key in fsFuncs as upvalue 2.
+type fsFunc func(ctx *C.lua_State, lfs *luaFS, name string) (n int, err error)
+
+var fsFuncs map[string]fsFunc
+
+func init() {
+	fsFuncs = map[string]fsFunc{
+		"read": fsRead,
+		"list": fsList,
+		"stat": fsStat,
+		"exists": fsExists,
+		"glob": fsGlob,
+		"write": fsWrite,
+		"append": fsWrite,
+		"mkdir": fsMkdir,
+		"remove": fsRemove,
+	}
+}
+
+var fsWriteFuncs = map[string]bool{"write": true, "append": true, "mkdir": true, "remove": true}
+
+func newFSModule(lfs *luaFS) *Module {
+	return &Module{name: "fs", open: func(ctx *C.lua_State) {
+		registerMetatable(ctx, goFSMeta, &metaMethod{
+			name: __gc, method: (C.lua_CFunction)(C.go_obj_free_wrap),
+		})
+		C.lua_createtable(ctx, 0, C.int(len(fsFuncs))) // [ fs ]
+		for name := range fsFuncs {
+			if fsWriteFuncs[name] && !lfs.writable {
+				continue
+			}
+			pushString(ctx, name) // [ fs name ]
+			pushValueWithMetatable(ctx, lfs, goFSMeta) // [ fs name lfs ]
+			pushString(ctx, name) // [ fs name lfs name ]
+			C.lua_pushcclosure(ctx, (C.lua_CFunction)(C.go_fs_func_wrap), 2) // [ fs name fn ] with upvalues lfs and name
+			C.lua_rawset(ctx, -3) // [ fs ]
+		}
+	}}
+}
+
+//export go_fs_func
+func go_fs_func(ctx *C.lua_State) C.int {
+	v, _ := getTargetValue(ctx, C.LUA_REGISTRYINDEX - 1) // upvalue 1
+	lfs, ok := v.(*luaFS)
+	if !ok {
+		return luaError(ctx, "fs not found")
+	}
+	var length C.size_t
+	key := C.lua_tolstring(ctx, C.LUA_REGISTRYINDEX - 2, &length) // upvalue 2
+	fn := fsFuncs[C.GoStringN(key, C.int(length))]
+
+	// [ 1 ] path
+	// ...
+	p, ok := getStringArg(ctx, 1)
+	if !ok {
+		return luaError(ctx, "bad argument #1 (string expected)")
+	}
+	name, err := fsPath(p)
+	if err != nil {
+		return luaError(ctx, err.Error())
+	}
+	n, err := fn(ctx, lfs, name)
+	if err != nil {
+		return luaError(ctx, fsError(err, p).Error())
+	}
+	return C.int(n)
+}
+
+// fsPath converts the path p to a name valid for fs.FS, or refuses it if it escapes the root.
+func fsPath(p string) (name string, err error) {
+	if p == "" {
+		return ".", nil
+	}
+	name = path.Clean(p)
+	if strings.HasPrefix(name, "/") {
+		if name = strings.TrimLeft(name, "/"); name == "" {
+			name = "."
+		}
+	}
+	if !fs.ValidPath(name) {
+		err = fmt.Errorf("path %s escapes the root", p)
+	}
+	return
+}
+
+// fsError removes the root directory from the errors of os.
+func fsError(err error, p string) error {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		return fmt.Errorf("%s %s: %v", pe.Op, p, pe.Err)
+	}
+	return err
+}
+
+// osPath returns the path in the OS of name, after the symbolic links in it are checked.
+func (lfs *luaFS) osPath(name string) (p string, err error) {
+	root, e := filepath.EvalSymlinks(lfs.root)
+	if e != nil {
+		err = e
+		return
+	}
+	p = filepath.Join(root, filepath.FromSlash(name))
+
+	// the longest existing part of p is resolved, the rest doesn't exist so it has no links
+	real, rest := p, ""
+	for {
+		r, e := filepath.EvalSymlinks(real)
+		if e == nil {
+			real = filepath.Join(r, rest)
+			break
+		}
+		if !errors.Is(e, fs.ErrNotExist) {
+			err = e
+			return
+		}
+		dir := filepath.Dir(real)
+		if dir == real {
+			break
+		}
+		rest = filepath.Join(filepath.Base(real), rest)
+		real = dir
+	}
+	if real != root && !strings.HasPrefix(real, root + string(filepath.Separator)) {
+		err = fmt.Errorf("path %s escapes the root", name)
+	}
+	return
+}
+
+// checkPath refuses the names of DirModule linked out of the root.
+func (lfs *luaFS) checkPath(name string) (err error) {
+	if len(lfs.root) > 0 {
+		_, err = lfs.osPath(name)
+	}
+	return
+}
+
+func fsRead(ctx *C.lua_State, lfs *luaFS, name string) (n int, err error) {
+	if err = lfs.checkPath(name); err != nil {
+		return
+	}
+	b, e := fs.ReadFile(lfs.fsys, name)
+	if e != nil {
+		err = e
+		return
+	}
+	pushString(ctx, string(b))
+	return 1, nil
+}
+
+func fsList(ctx *C.lua_State, lfs *luaFS, name string) (n int, err error) {
+	if err = lfs.checkPath(name); err != nil {
+		return
+	}
+	entries, e := fs.ReadDir(lfs.fsys, name)
+	if e != nil {
+		err = e
+		return
+	}
+	C.lua_createtable(ctx, C.int(len(entries)), 0) // [ ... list ]
+	for i, entry := range entries {
+		pushString(ctx, entry.Name()) // [ ... list name ]
+		C.lua_rawseti(ctx, -2, C.lua_Integer(i+1)) // [ ... list ]
+	}
+	return 1, nil
+}
+
+func fsStat(ctx *C.lua_State, lfs *luaFS, name string) (n int, err error) {
+	if err = lfs.checkPath(name); err != nil {
+		return
+	}
+	fi, e := fs.Stat(lfs.fsys, name)
+	if e != nil {
+		err = e
+		return
+	}
+	C.lua_createtable(ctx, 0, 5) // [ ... stat ]
+	pushString(ctx, "name") // [ ... stat "name" ]
+	pushString(ctx, fi.Name()) // [ ... stat "name" name ]
+	C.lua_rawset(ctx, -3) // [ ... stat ]
+	pushString(ctx, "size") // [ ... stat "size" ]
+	C.lua_pushinteger(ctx, C.lua_Integer(fi.Size())) // [ ... stat "size" size ]
+	C.lua_rawset(ctx, -3) // [ ... stat ]
+	pushString(ctx, "is_dir") // [ ... stat "is_dir" ]
+	pushBool(ctx, fi.IsDir()) // [ ... stat "is_dir" is_dir ]
+	C.lua_rawset(ctx, -3) // [ ... stat ]
+	pushString(ctx, "mode") // [ ... stat "mode" ]
+	pushString(ctx, fi.Mode().String()) // [ ... stat "mode" mode ]
+	C.lua_rawset(ctx, -3) // [ ... stat ]
+	pushString(ctx, "mod_time") // [ ... stat "mod_time" ]
+	pushTime(ctx, fi.ModTime()) // [ ... stat "mod_time" mod_time ]
+	C.lua_rawset(ctx, -3) // [ ... stat ]
+	return 1, nil
+}
+
+func fsExists(ctx *C.lua_State, lfs *luaFS, name string) (n int, err error) {
+	if err = lfs.checkPath(name); err != nil {
+		return
+	}
+	_, e := fs.Stat(lfs.fsys, name)
+	switch {
+	case e == nil:
+		pushBool(ctx, true)
+	case errors.Is(e, fs.ErrNotExist):
+		pushBool(ctx, false)
+	default:
+		err = e
+		return
+	}
+	return 1, nil
+}
+
+func fsGlob(ctx *C.lua_State, lfs *luaFS, pattern string) (n int, err error) {
+	matches, e := fs.Glob(lfs.fsys, pattern)
+	if e != nil {
+		err = e
+		return
+	}
+	sort.Strings(matches)
+	C.lua_createtable(ctx, C.int(len(matches)), 0) // [ ... list ]
+	i := 0
+	for _, m := range matches {
+		if lfs.checkPath(m) != nil {
+			continue // linked out of the root
+		}
+		i += 1
+		pushString(ctx, m) // [ ... list path ]
+		C.lua_rawseti(ctx, -2, C.lua_Integer(i)) // [ ... list ]
+	}
+	return 1, nil
+}
+
+func fsWrite(ctx *C.lua_State, lfs *luaFS, name string) (n int, err error) {
+	// [ 1 ] path
+	// [ 2 ] data
+	data, ok := getStringArg(ctx, 2)
+	if !ok {
+		err = fmt.Errorf("bad argument #2 (string expected)")
+		return
+	}
+	p, e := lfs.osPath(name)
+	if e != nil {
+		err = e
+		return
+	}
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	var key string
+	if key, ok = getStringArg(ctx, C.LUA_REGISTRYINDEX - 2); ok && key == "append" {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, e := os.OpenFile(p, flag, 0644)
+	if e != nil {
+		err = e
+		return
+	}
+	_, err = f.WriteString(data)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	return
+}
+
+func fsMkdir(ctx *C.lua_State, lfs *luaFS, name string) (n int, err error) {
+	p, e := lfs.osPath(name)
+	if e != nil {
+		err = e
+		return
+	}
+	err = os.MkdirAll(p, 0755)
+	return
+}
+
+func fsRemove(ctx *C.lua_State, lfs *luaFS, name string) (n int, err error) {
+	if name == "." {
+		err = fmt.Errorf("the root cannot be removed")
+		return
+	}
+	// a symbolic link is removed rather than the file linked
+	dir, e := lfs.osPath(path.Dir(name))
+	if e != nil {
+		err = e
+		return
+	}
+	err = os.Remove(filepath.Join(dir, path.Base(name)))
+	return
+}
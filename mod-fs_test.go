@@ -0,0 +1,76 @@
+package lua
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFSModule(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.txt": {Data: []byte("hello")},
+		"dir/b.lua": {Data: []byte("return 1")},
+		"dir/c.lua": {Data: []byte("return 2")},
+	}
+	ctx, err := NewContext(WithModules(FSModule(fsys)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.LoadScript(`
+		assert(fs.read("a.txt") == "hello" and fs.read("/a.txt") == "hello")
+		local names = fs.list("dir")
+		assert(#names == 2 and names[1] == "b.lua")
+		local st = fs.stat("a.txt")
+		assert(st.name == "a.txt" and st.size == 5 and not st.is_dir)
+		assert(fs.stat("dir").is_dir)
+		assert(fs.exists("dir/c.lua") and not fs.exists("x"))
+		assert(#fs.glob("dir/*.lua") == 2)
+		assert(not pcall(fs.read, "../a.txt"))
+		assert(not pcall(fs.read, "x"))
+		assert(fs.write == nil)
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDirModule(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("s"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := NewContext(WithModules(DirModule(root, true)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.LoadScript(`
+		fs.mkdir("x/y")
+		fs.write("x/y/a.txt", "a")
+		fs.append("x/y/a.txt", "b")
+		assert(fs.read("x/y/a.txt") == "ab")
+		fs.remove("x/y/a.txt")
+		assert(not fs.exists("x/y/a.txt"))
+		assert(not pcall(fs.write, "../escaped", "a"))
+		assert(not pcall(fs.read, "link/secret"))
+		assert(not pcall(fs.remove, "x"))
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(filepath.Dir(root), "escaped")); err == nil {
+		t.Fatal("file written out of root")
+	}
+
+	ctx, err = NewContext(WithModules(DirModule(root, false)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ctx.LoadScript(`assert(fs.exists("x/y") and fs.write == nil)`, nil); err != nil {
+		t.Fatal(err)
+	}
+}
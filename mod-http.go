@@ -0,0 +1,293 @@
+package lua
+
+// #include "lua.h"
+// #include "lauxlib.h"
+// static void popN(lua_State *L, int n);
+// extern int go_http_request_wrap(lua_State *ctx);
+// extern int go_obj_free_wrap(lua_State *ctx);
+import "C"
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"fmt"
+)
+
+// HTTPOptions configures the Lua module `http` returned by HTTPModule().
+type HTTPOptions struct {
+	// Client sends the requests, a client like http.DefaultClient is used if nil.
+	Client *http.Client
+
+	// AllowedHosts are the hosts which can be requested, such as "api.example.com",
+	// "localhost:8080" with a port, or "*.example.com" for the subdomains. No host
+	// can be requested if it is empty.
+	AllowedHosts []string
+
+	// Timeout is the default timeout of a request, 0 means no timeout.
+	Timeout time.Duration
+
+	// MaxBodySize is the max size of a response body, 0 means no limit.
+	MaxBodySize int64
+}
+
+// HTTPModule returns the Lua module `http`:
+//
+//   http.request{method=, url=, headers=, body=, timeout=} sends a request and returns
+//     a table {status=, headers=, body=}
+//
+// method defaults to "GET", headers is a table of names and values, timeout is in seconds
+// or a duration of the module `time`, which overrides HTTPOptions.Timeout. The values of
+// a response header are joined by ", ". Requests, including the redirects, to the hosts
+// not allowed are refused. Requests are canceled once the Go context of WithContext() is done.
+func HTTPModule(opts HTTPOptions) *Module {
+	m := &httpModule{opts: opts}
+	if opts.Client == nil {
+		m.client = &http.Client{}
+	} else {
+		client := *opts.Client
+		m.client = &client
+	}
+	checkRedirect := m.client.CheckRedirect
+	m.client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if !m.isAllowed(req.URL) {
+			return fmt.Errorf("redirect to host %s not allowed", req.URL.Host)
+		}
+		if checkRedirect != nil {
+			return checkRedirect(req, via)
+		}
+		if len(via) >= 10 {
+			return fmt.Errorf("stopped after 10 redirects")
+		}
+		return nil
+	}
+
+	return &Module{name: "http", open: func(ctx *C.lua_State) {
+		registerMetatable(ctx, goHTTPMeta, &metaMethod{
+			name: __gc, method: (C.lua_CFunction)(C.go_obj_free_wrap),
+		})
+		C.lua_createtable(ctx, 0, 1) // [ http ]
+		pushString(ctx, "request") // [ http "request" ]
+		pushValueWithMetatable(ctx, m, goHTTPMeta) // [ http "request" m ]
+		C.lua_pushcclosure(ctx, (C.lua_CFunction)(C.go_http_request_wrap), 1) // [ http "request" fn ] with m as upvalue
+		C.lua_rawset(ctx, -3) // [ http ]
+	}}
+}
+
+var goHTTPMeta = "goHTTPMeta\x00"
+
+type httpModule struct {
+	opts HTTPOptions
+	client *http.Client // a copy of opts.Client checking the hosts of redirects
+}
+
+func (m *httpModule) isAllowed(u *url.URL) bool {
+	host, hostPort := strings.ToLower(u.Hostname()), strings.ToLower(u.Host)
+	for _, allowed := range m.opts.AllowedHosts {
+		allowed = strings.ToLower(allowed)
+		switch {
+		case allowed == host, allowed == hostPort:
+			return true
+		case strings.HasPrefix(allowed, "*."):
+			if strings.HasSuffix(host, allowed[1:]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// httpRequest is the table of http.request{}
+type httpRequest struct {
+	method string
+	url string
+	headers http.Header
+	body string
+	timeout time.Duration
+}
+
+func getStringField(ctx *C.lua_State, name string) (s string, err error) {
+	// [ 1 ] request
+	pushString(ctx, name) // [ request name ]
+	C.lua_rawget(ctx, 1) // [ request val ]
+	defer C.popN(ctx, 1) // [ request ]
+	if C.lua_type(ctx, -1) == C.LUA_TNIL {
+		return
+	}
+	var ok bool
+	if s, ok = getStringArg(ctx, -1); !ok {
+		err = fmt.Errorf("bad field %s (string expected)", name)
+	}
+	return
+}
+
+func readHTTPRequest(ctx *C.lua_State, defTimeout time.Duration) (req *httpRequest, err error) {
+	// [ 1 ] request
+	if C.lua_type(ctx, 1) != C.LUA_TTABLE {
+		err = fmt.Errorf("bad argument #1 (table expected)")
+		return
+	}
+	req = &httpRequest{headers: make(http.Header), timeout: defTimeout}
+	if req.method, err = getStringField(ctx, "method"); err != nil {
+		return
+	}
+	if req.method == "" {
+		req.method = http.MethodGet
+	}
+	if req.url, err = getStringField(ctx, "url"); err != nil {
+		return
+	}
+	if req.body, err = getStringField(ctx, "body"); err != nil {
+		return
+	}
+
+	pushString(ctx, "headers") // [ request "headers" ]
+	C.lua_rawget(ctx, 1) // [ request headers ]
+	err = readHTTPHeaders(ctx, req.headers)
+	C.popN(ctx, 1) // [ request ]
+	if err != nil {
+		return
+	}
+
+	pushString(ctx, "timeout") // [ request "timeout" ]
+	C.lua_rawget(ctx, 1) // [ request timeout ]
+	defer C.popN(ctx, 1) // [ request ]
+	switch C.lua_type(ctx, -1) {
+	case C.LUA_TNIL:
+	case C.LUA_TNUMBER:
+		req.timeout = time.Duration(float64(C.lua_tonumberx(ctx, -1, nil)) * float64(time.Second))
+	default:
+		var ok bool
+		if req.timeout, ok = getDuration(ctx, -1); !ok {
+			err = fmt.Errorf("bad field timeout (seconds or duration expected)")
+		}
+	}
+	return
+}
+
+func readHTTPHeaders(ctx *C.lua_State, headers http.Header) (err error) {
+	// [ ... headers ]
+	switch C.lua_type(ctx, -1) {
+	case C.LUA_TNIL:
+		return
+	case C.LUA_TTABLE:
+	default:
+		return fmt.Errorf("bad field headers (table expected)")
+	}
+	C.lua_pushnil(ctx) // [ ... headers nil ]
+	for C.lua_next(ctx, -2) != 0 {
+		// [ ... headers name value ]
+		if C.lua_type(ctx, -2) != C.LUA_TSTRING {
+			// a number key must not be converted in place, which confuses lua_next
+			C.popN(ctx, 2) // [ ... headers ]
+			return fmt.Errorf("bad field headers (strings expected)")
+		}
+		name, _ := getStringArg(ctx, -2)
+		value, ok := getStringArg(ctx, -1)
+		C.popN(ctx, 1) // [ ... headers name ]
+		if !ok {
+			C.popN(ctx, 1) // [ ... headers ]
+			return fmt.Errorf("bad field headers (strings expected)")
+		}
+		headers.Add(name, value)
+	}
+	// [ ... headers ]
+	return
+}
+
+//export go_http_request
+func go_http_request(ctx *C.lua_State) C.int {
+	// [ 1 ] request
+	v, _ := getTargetValue(ctx, C.LUA_REGISTRYINDEX - 1) // upvalue 1
+	m, ok := v.(*httpModule)
+	if !ok {
+		return luaError(ctx, "http not found")
+	}
+	req, err := readHTTPRequest(ctx, m.opts.Timeout)
+	if err != nil {
+		return luaError(ctx, err.Error())
+	}
+	status, headers, body, err := m.do(getGoContext(ctx), req)
+	if err != nil {
+		return luaError(ctx, err.Error())
+	}
+
+	C.lua_createtable(ctx, 0, 3) // [ request resp ]
+	pushString(ctx, "status") // [ request resp "status" ]
+	C.lua_pushinteger(ctx, C.lua_Integer(status)) // [ request resp "status" status ]
+	C.lua_rawset(ctx, -3) // [ request resp ]
+
+	pushString(ctx, "headers") // [ request resp "headers" ]
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	C.lua_createtable(ctx, 0, C.int(len(names))) // [ request resp "headers" headers ]
+	for _, name := range names {
+		pushString(ctx, name) // [ request resp "headers" headers name ]
+		pushString(ctx, strings.Join(headers[name], ", ")) // [ request resp "headers" headers name value ]
+		C.lua_rawset(ctx, -3) // [ request resp "headers" headers ]
+	}
+	C.lua_rawset(ctx, -3) // [ request resp ]
+
+	pushString(ctx, "body") // [ request resp "body" ]
+	pushString(ctx, string(body)) // [ request resp "body" body ]
+	C.lua_rawset(ctx, -3) // [ request resp ]
+	return 1
+}
+
+func (m *httpModule) do(goCtx context.Context, req *httpRequest) (status int, headers http.Header, body []byte, err error) {
+	u, e := url.Parse(req.url)
+	if e != nil {
+		err = e
+		return
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		err = fmt.Errorf("unsupported scheme %q", u.Scheme)
+		return
+	}
+	if !m.isAllowed(u) {
+		err = fmt.Errorf("host %s not allowed", u.Host)
+		return
+	}
+
+	if req.timeout > 0 {
+		var cancel context.CancelFunc
+		goCtx, cancel = context.WithTimeout(goCtx, req.timeout)
+		defer cancel()
+	}
+	var reqBody io.Reader
+	if len(req.body) > 0 {
+		reqBody = strings.NewReader(req.body)
+	}
+	httpReq, e := http.NewRequestWithContext(goCtx, req.method, u.String(), reqBody)
+	if e != nil {
+		err = e
+		return
+	}
+	httpReq.Header = req.headers
+
+	resp, e := m.client.Do(httpReq)
+	if e != nil {
+		err = e
+		return
+	}
+	defer resp.Body.Close()
+
+	var r io.Reader = resp.Body
+	if m.opts.MaxBodySize > 0 {
+		r = io.LimitReader(resp.Body, m.opts.MaxBodySize + 1)
+	}
+	if body, err = io.ReadAll(r); err != nil {
+		return
+	}
+	if m.opts.MaxBodySize > 0 && int64(len(body)) > m.opts.MaxBodySize {
+		err = fmt.Errorf("response body exceeds %d bytes", m.opts.MaxBodySize)
+		return
+	}
+	return resp.StatusCode, resp.Header, body, nil
+}
@@ -0,0 +1,65 @@
+package lua
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"fmt"
+)
+
+func newHTTPTestContext(t *testing.T, opts HTTPOptions) (*LuaContext, string) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://example.com/", http.StatusFound)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Echo", r.Header.Get("X-A"))
+		fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.Path, body)
+	}))
+	t.Cleanup(srv.Close)
+
+	u, _ := url.Parse(srv.URL)
+	opts.AllowedHosts = append(opts.AllowedHosts, u.Host)
+	ctx, err := NewContext(WithModules(HTTPModule(opts)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ctx, srv.URL
+}
+
+func TestHTTPRequest(t *testing.T) {
+	ctx, base := newHTTPTestContext(t, HTTPOptions{})
+	err := ctx.LoadScript(`
+		local resp = http.request{method = "POST", url = base .. "/a", headers = {["X-A"] = "1"}, body = "hi"}
+		assert(resp.status == 200, resp.status)
+		assert(resp.body == "POST /a hi", resp.body)
+		assert(resp.headers["X-Echo"] == "1")
+	`, map[string]interface{}{"base": base})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHTTPRefused(t *testing.T) {
+	ctx, base := newHTTPTestContext(t, HTTPOptions{MaxBodySize: 4})
+	for _, script := range []string{
+		`http.request{url = "http://example.com/"}`,
+		`http.request{url = base .. "/redirect"}`,
+		`http.request{url = "file:///etc/passwd"}`,
+		`http.request{url = base .. "/too-long"}`,
+		`http.request{url = base, headers = {"X-A"}}`,
+		`http.request{url = base, headers = {[1] = "x", ["X-A"] = "1"}}`,
+	} {
+		err := ctx.LoadScript(script, map[string]interface{}{"base": base})
+		if err == nil {
+			t.Fatalf("%s: error expected", script)
+		}
+		if strings.Contains(script, "headers") && !strings.Contains(err.Error(), "bad field headers") {
+			t.Fatalf("%s: unexpected error %v", script, err)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package lua
+
+// #include "lua.h"
+// #include "lauxlib.h"
+// static void popN(lua_State *L, int n);
+// extern int go_json_encode_wrap(lua_State *ctx);
+// extern int go_json_decode_wrap(lua_State *ctx);
+import "C"
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unsafe"
+	"fmt"
+)
+
+// JSONModule is the Lua module `json`:
+//
+//   json.encode(value, opts) encodes a Lua value to JSON, opts is an optional table with
+//     indent: string or true for 2 spaces, the output is pretty printed if set
+//     sort_keys: true to sort the keys of objects
+//   json.decode(s) decodes JSON to a Lua value
+//   json.null is the sentinel of JSON null
+//   json.array(t) marks t as an array, so an empty t is encoded as `[]`
+//
+// Integers and floats of Lua are kept in both directions, arrays decoded are marked
+// as arrays, the nil elements of arrays such as `{1, nil, 3}` are encoded as null,
+// and Go values are encoded by encoding/json.
+var JSONModule = &Module{name: "json", open: openJSONModule}
+
+var jsonArrayMeta = "luago.json.array\x00"
+
+func openJSONModule(ctx *C.lua_State) {
+	var name *C.char
+
+	// the metatable marking tables decoded from arrays, see isArrayTable()
+	C.lua_createtable(ctx, 0, 1) // [ meta ]
+	C.lua_pushboolean(ctx, 1) // [ meta true ]
+	flag := arrayFlag + "\x00"
+	getStrPtr(&flag, &name)
+	C.lua_setfield(ctx, -2, name) // [ meta ] with meta.__array = true
+	getStrPtr(&jsonArrayMeta, &name)
+	C.lua_setfield(ctx, C.LUA_REGISTRYINDEX, name) // [ ]
+
+	newModuleTable(ctx,
+		moduleFunc{"encode", (C.lua_CFunction)(C.go_json_encode_wrap)},
+		moduleFunc{"decode", (C.lua_CFunction)(C.go_json_decode_wrap)},
+	) // [ json ]
+	pushString(ctx, "null") // [ json "null" ]
+	C.lua_pushlightuserdata(ctx, nil) // [ json "null" NULL ]
+	C.lua_rawset(ctx, -3) // [ json ]
+
+	pushString(ctx, "array") // [ json "array" ]
+	array := "array\x00"
+	getStrPtr(&array, &name)
+	C.lua_getglobal(ctx, name) // [ json "array" array ], see arrayHelper
+	C.lua_rawset(ctx, -3) // [ json ]
+}
+
+type jsonEncoder struct {
+	b bytes.Buffer
+	sortKeys bool
+	visiting map[unsafe.Pointer]bool
+}
+
+//export go_json_encode
+func go_json_encode(ctx *C.lua_State) C.int {
+	// [ 1 ] value
+	// [ 2 ] opts, optional
+	e := &jsonEncoder{visiting: make(map[unsafe.Pointer]bool)}
+	indent := ""
+	if C.lua_type(ctx, 2) == C.LUA_TTABLE {
+		pushString(ctx, "indent") // [ value opts "indent" ]
+		switch C.lua_rawget(ctx, 2) { // [ value opts indent ]
+		case C.LUA_TSTRING:
+			indent = C.GoString(C.lua_tolstring(ctx, -1, nil))
+		case C.LUA_TBOOLEAN:
+			if C.lua_toboolean(ctx, -1) != 0 {
+				indent = "  "
+			}
+		}
+		pushString(ctx, "sort_keys") // [ value opts indent "sort_keys" ]
+		C.lua_rawget(ctx, 2) // [ value opts indent sort_keys ]
+		e.sortKeys = C.lua_toboolean(ctx, -1) != 0
+		C.popN(ctx, 2) // [ value opts ]
+	}
+
+	if err := e.encode(ctx, 1, "$"); err != nil {
+		return luaError(ctx, fmt.Sprintf("json.encode: %v", err))
+	}
+	out := e.b.Bytes()
+	if len(indent) > 0 {
+		pretty := &bytes.Buffer{}
+		if err := json.Indent(pretty, out, "", indent); err != nil {
+			return luaError(ctx, fmt.Sprintf("json.encode: %v", err))
+		}
+		out = pretty.Bytes()
+	}
+	pushString(ctx, string(out))
+	return 1
+}
+
+// encode encodes the value at the index idx, path is used in errors.
+func (e *jsonEncoder) encode(ctx *C.lua_State, idx C.int, path string) error {
+	switch C.lua_type(ctx, idx) {
+	case C.LUA_TNIL:
+		e.b.WriteString("null")
+	case C.LUA_TLIGHTUSERDATA:
+		if C.lua_touserdata(ctx, idx) != nil {
+			return fmt.Errorf("cannot encode light userdata at %s", path)
+		}
+		e.b.WriteString("null") // json.null
+	case C.LUA_TBOOLEAN:
+		if C.lua_toboolean(ctx, idx) != 0 {
+			e.b.WriteString("true")
+		} else {
+			e.b.WriteString("false")
+		}
+	case C.LUA_TNUMBER:
+		if C.lua_isinteger(ctx, idx) != 0 {
+			e.b.WriteString(strconv.FormatInt(int64(C.lua_tointegerx(ctx, idx, nil)), 10))
+			break
+		}
+		f := float64(C.lua_tonumberx(ctx, idx, nil))
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return fmt.Errorf("cannot encode %v at %s", f, path)
+		}
+		b, _ := json.Marshal(f)
+		e.b.Write(b)
+		if !bytes.ContainsAny(b, ".eE") {
+			e.b.WriteString(".0") // kept as float when decoded
+		}
+	case C.LUA_TSTRING:
+		var length C.size_t
+		s := C.lua_tolstring(ctx, idx, &length)
+		e.writeString(*(toString(s, int(length))))
+	case C.LUA_TTABLE:
+		return e.encodeTable(ctx, idx, path)
+	case C.LUA_TUSERDATA:
+		v, ok := getTargetValue(ctx, idx)
+		if !ok {
+			return fmt.Errorf("cannot encode userdata at %s", path)
+		}
+		if b, ok := v.(Bytes); ok {
+			e.writeString(string(b))
+			break
+		}
+		if _, ok := v.(*goMethod); ok {
+			return fmt.Errorf("cannot encode go function at %s", path)
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("%v at %s", err, path)
+		}
+		e.b.Write(b)
+	default:
+		return fmt.Errorf("cannot encode %s at %s", C.GoString(C.lua_typename(ctx, C.lua_type(ctx, idx))), path)
+	}
+	return nil
+}
+
+func (e *jsonEncoder) writeString(s string) {
+	enc := json.NewEncoder(&e.b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	e.b.Truncate(e.b.Len() - 1) // the newline written by Encode()
+}
+
+type jsonMember struct {
+	key string
+	val []byte
+}
+
+func (e *jsonEncoder) encodeTable(ctx *C.lua_State, idx C.int, path string) (err error) {
+	p := C.lua_topointer(ctx, idx)
+	if e.visiting[p] {
+		return fmt.Errorf("cycle found at %s", path)
+	}
+	e.visiting[p] = true
+	defer delete(e.visiting, p)
+	if C.lua_checkstack(ctx, 3) == 0 {
+		return fmt.Errorf("too deep at %s", path)
+	}
+
+	C.lua_pushvalue(ctx, idx) // [ ... table ]
+	isArray := isArrayTable(ctx)
+	C.popN(ctx, 1) // [ ... ]
+
+	// collect the members, which are also the elements of an array
+	n := int64(C.lua_rawlen(ctx, idx))
+	var members []jsonMember
+	isSeq := true
+	C.lua_pushnil(ctx) // [ ... nil ]
+	for C.lua_next(ctx, idx) != 0 {
+		// [ ... key val ]
+		var key string
+		switch C.lua_type(ctx, -2) {
+		case C.LUA_TSTRING:
+			key = C.GoString(C.lua_tolstring(ctx, -2, nil))
+			isSeq = false
+		case C.LUA_TNUMBER:
+			if C.lua_isinteger(ctx, -2) == 0 {
+				C.popN(ctx, 2) // [ ... ]
+				return fmt.Errorf("float key found at %s", path)
+			}
+			i := int64(C.lua_tointegerx(ctx, -2, nil))
+			if i < 1 || i > n {
+				isSeq = false
+			}
+			key = strconv.FormatInt(i, 10)
+		default:
+			C.popN(ctx, 2) // [ ... ]
+			return fmt.Errorf("key of %s found at %s", C.GoString(C.lua_typename(ctx, C.lua_type(ctx, -2))), path)
+		}
+
+		start := e.b.Len()
+		valPath := path + "." + key
+		if err = e.encode(ctx, C.lua_gettop(ctx), valPath); err != nil {
+			C.popN(ctx, 2) // [ ... ]
+			return
+		}
+		val := append([]byte(nil), e.b.Bytes()[start:]...)
+		e.b.Truncate(start)
+		members = append(members, jsonMember{key, val})
+		C.popN(ctx, 1) // [ ... key ]
+	}
+
+	switch {
+	case isSeq && (n > 0 || isArray):
+		// the holes of the table, indices in 1..n without values, are encoded as null
+		vals := make([][]byte, n)
+		for _, m := range members {
+			i, _ := strconv.ParseInt(m.key, 10, 64)
+			vals[i-1] = m.val
+		}
+		e.b.WriteByte('[')
+		for i, val := range vals {
+			if i > 0 {
+				e.b.WriteByte(',')
+			}
+			if val == nil {
+				e.b.WriteString("null")
+			} else {
+				e.b.Write(val)
+			}
+		}
+		e.b.WriteByte(']')
+	case isArray:
+		return fmt.Errorf("table marked as array has non-index keys at %s", path)
+	default:
+		if e.sortKeys {
+			sort.Slice(members, func(i, j int) bool {
+				return members[i].key < members[j].key
+			})
+		}
+		e.b.WriteByte('{')
+		for i, m := range members {
+			if i > 0 {
+				e.b.WriteByte(',')
+			}
+			e.writeString(m.key)
+			e.b.WriteByte(':')
+			e.b.Write(m.val)
+		}
+		e.b.WriteByte('}')
+	}
+	return
+}
+
+//export go_json_decode
+func go_json_decode(ctx *C.lua_State) C.int {
+	// [ 1 ] s
+	if C.lua_type(ctx, 1) != C.LUA_TSTRING {
+		return luaError(ctx, "bad argument #1 to 'decode' (string expected)")
+	}
+	var length C.size_t
+	s := C.lua_tolstring(ctx, 1, &length)
+
+	dec := json.NewDecoder(bytes.NewReader(toBytes(s, int(length))))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return luaError(ctx, fmt.Sprintf("json.decode: %v", err))
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return luaError(ctx, "json.decode: invalid data after top-level value")
+	}
+	if err := pushJSONValue(ctx, v); err != nil {
+		return luaError(ctx, fmt.Sprintf("json.decode: %v", err))
+	}
+	return 1
+}
+
+func pushJSONValue(ctx *C.lua_State, v interface{}) error {
+	if C.lua_checkstack(ctx, 3) == 0 {
+		return fmt.Errorf("too deep")
+	}
+	switch vv := v.(type) {
+	case nil:
+		C.lua_pushlightuserdata(ctx, nil) // json.null
+	case bool:
+		if vv {
+			C.lua_pushboolean(ctx, 1)
+		} else {
+			C.lua_pushboolean(ctx, 0)
+		}
+	case json.Number:
+		if !strings.ContainsAny(string(vv), ".eE") {
+			if i, err := vv.Int64(); err == nil {
+				C.lua_pushinteger(ctx, C.lua_Integer(i))
+				break
+			}
+		}
+		f, err := vv.Float64()
+		if err != nil {
+			return err
+		}
+		C.lua_pushnumber(ctx, C.lua_Number(f))
+	case string:
+		pushString(ctx, vv)
+	case []interface{}:
+		C.lua_createtable(ctx, C.int(len(vv)), 0) // [ arr ]
+		for i, e := range vv {
+			if err := pushJSONValue(ctx, e); err != nil { // [ arr e ]
+				C.popN(ctx, 1) // [ ]
+				return err
+			}
+			C.lua_rawseti(ctx, -2, C.lua_Integer(i+1)) // [ arr ]
+		}
+		var name *C.char
+		getStrPtr(&jsonArrayMeta, &name)
+		C.lua_getfield(ctx, C.LUA_REGISTRYINDEX, name) // [ arr meta ]
+		C.lua_setmetatable(ctx, -2) // [ arr ]
+	case map[string]interface{}:
+		C.lua_createtable(ctx, 0, C.int(len(vv))) // [ obj ]
+		for k, e := range vv {
+			pushString(ctx, k) // [ obj k ]
+			if err := pushJSONValue(ctx, e); err != nil { // [ obj k e ]
+				C.popN(ctx, 2) // [ ]
+				return err
+			}
+			C.lua_rawset(ctx, -3) // [ obj ]
+		}
+	default:
+		return fmt.Errorf("unexpected value %v", v)
+	}
+	return nil
+}
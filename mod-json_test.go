@@ -0,0 +1,64 @@
+package lua
+
+import (
+	"testing"
+)
+
+func TestJSONEncode(t *testing.T) {
+	ctx, err := NewContext(WithModules(JSONModule))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct{
+		value string
+		want string
+	}{
+		{`{1, 2.5, "a", true}`, `[1,2.5,"a",true]`},
+		{`{1, nil, 3}`, `[1,null,3]`},
+		{`{b = 1, a = {}}`, `{"a":{},"b":1}`},
+		{`json.array{}`, `[]`},
+		{`{x = json.null}`, `{"x":null}`},
+		{`json.decode('[1, 2.0, {"k": []}]')`, `[1,2.0,{"k":[]}]`},
+	} {
+		if err = ctx.LoadScript("out = json.encode(" + c.value + ", {sort_keys = true})", nil); err != nil {
+			t.Fatalf("%s: %v", c.value, err)
+		}
+		out, _ := ctx.GetGlobal("out")
+		if out != c.want {
+			t.Fatalf("%s: %s expected, got %v", c.value, c.want, out)
+		}
+	}
+}
+
+func TestJSONEncodeErrors(t *testing.T) {
+	ctx, err := NewContext(WithModules(JSONModule))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{
+		`(function() local t = {}; t.self = t; return t end)()`,
+		`{[1.5] = 1}`,
+		`{0/0}`,
+		`json.array{a = 1}`,
+	} {
+		if err = ctx.LoadScript("json.encode(" + value + ")", nil); err == nil {
+			t.Fatalf("%s: error expected", value)
+		}
+	}
+}
+
+func TestJSONDecode(t *testing.T) {
+	ctx, err := NewContext(WithModules(JSONModule))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.LoadScript(`
+		local v = json.decode('{"a": [1, 2.5, null], "s": "x"}')
+		assert(math.type(v.a[1]) == "integer" and v.a[2] == 2.5 and v.a[3] == json.null)
+		assert(v.s == "x")
+		assert(not pcall(json.decode, "{bad"))
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
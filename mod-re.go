@@ -0,0 +1,313 @@
+package lua
+
+// #include "lua.h"
+// #include "lauxlib.h"
+// static void popN(lua_State *L, int n);
+// extern int go_re_compile_wrap(lua_State *ctx);
+// extern int go_re_match_wrap(lua_State *ctx);
+// extern int go_re_find_wrap(lua_State *ctx);
+// extern int go_re_find_all_wrap(lua_State *ctx);
+// extern int go_re_gsub_wrap(lua_State *ctx);
+// extern int go_re_split_wrap(lua_State *ctx);
+// extern int go_re_tostring_wrap(lua_State *ctx);
+// extern int go_obj_free_wrap(lua_State *ctx);
+import "C"
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"fmt"
+)
+
+// RegexpModule is the Lua module `re` of Go regular expressions (RE2 syntax):
+//
+//   re.compile(p) returns a compiled regexp, which has the methods below
+//   re.match(p, s) returns true if s contains a match of p
+//   re.find(p, s) returns the captures of the first match, or nil
+//   re.find_all(p, s, n) returns a list of the captures of at most n matches, all if n is omitted
+//   re.gsub(p, s, repl, n) replaces at most n matches with repl, returns the result and the number
+//     of matches. repl is a string with `$1` or `${name}` expanded, or a function called with the
+//     match and the captures, returning the replacement, nil or false to keep the match.
+//   re.split(p, s, n) returns a list of the substrings between the matches
+//
+// p is a pattern string or a compiled regexp, patterns are compiled once and cached.
+// The captures of a match are a table with the match at index 0, the groups from index 1,
+// and the named groups by names.
+var RegexpModule = &Module{name: "re", open: openRegexpModule}
+
+var goRegexpMeta = "goRegexpMeta\x00"
+
+func openRegexpModule(ctx *C.lua_State) {
+	funcs := []moduleFunc{
+		{"match", (C.lua_CFunction)(C.go_re_match_wrap)},
+		{"find", (C.lua_CFunction)(C.go_re_find_wrap)},
+		{"find_all", (C.lua_CFunction)(C.go_re_find_all_wrap)},
+		{"gsub", (C.lua_CFunction)(C.go_re_gsub_wrap)},
+		{"split", (C.lua_CFunction)(C.go_re_split_wrap)},
+	}
+
+	// methods of compiled regexps are the same functions
+	var name *C.char
+	registerMetatable(ctx, goRegexpMeta, &metaMethod{
+		name: __tostring, method: (C.lua_CFunction)(C.go_re_tostring_wrap),
+	}, &metaMethod{
+		name: __gc, method: (C.lua_CFunction)(C.go_obj_free_wrap),
+	})
+	getStrPtr(&goRegexpMeta, &name)
+	C.lua_getfield(ctx, C.LUA_REGISTRYINDEX, name) // [ metatable ]
+	newModuleTable(ctx, funcs...) // [ metatable methods ]
+	getStrPtr(&__index, &name)
+	C.lua_setfield(ctx, -2, name) // [ metatable ] with metatable.__index = methods
+	C.popN(ctx, 1) // [ ]
+
+	newModuleTable(ctx, append(funcs, moduleFunc{"compile", (C.lua_CFunction)(C.go_re_compile_wrap)})...) // [ re ]
+}
+
+const reCacheSize = 512
+
+var reCache = struct {
+	sync.Mutex
+	m map[string]*regexp.Regexp
+}{m: make(map[string]*regexp.Regexp)}
+
+func compileRegexp(pattern string) (re *regexp.Regexp, err error) {
+	reCache.Lock()
+	defer reCache.Unlock()
+
+	if re = reCache.m[pattern]; re != nil {
+		return
+	}
+	if re, err = regexp.Compile(pattern); err != nil {
+		return
+	}
+	if len(reCache.m) >= reCacheSize {
+		reCache.m = make(map[string]*regexp.Regexp)
+	}
+	reCache.m[pattern] = re
+	return
+}
+
+// getRegexp gets the pattern string or the compiled regexp at index 1.
+func getRegexp(ctx *C.lua_State) (re *regexp.Regexp, err error) {
+	switch C.lua_type(ctx, 1) {
+	case C.LUA_TSTRING:
+		return compileRegexp(C.GoString(C.lua_tolstring(ctx, 1, nil)))
+	case C.LUA_TUSERDATA:
+		if v, ok := getTargetValue(ctx, 1); ok {
+			if re, ok = v.(*regexp.Regexp); ok {
+				return
+			}
+		}
+	}
+	err = fmt.Errorf("bad argument #1 (pattern or regexp expected)")
+	return
+}
+
+func getStringArg(ctx *C.lua_State, idx C.int) (s string, ok bool) {
+	if C.lua_type(ctx, idx) != C.LUA_TSTRING && C.lua_type(ctx, idx) != C.LUA_TNUMBER {
+		return
+	}
+	var length C.size_t
+	p := C.lua_tolstring(ctx, idx, &length)
+	return C.GoStringN(p, C.int(length)), true
+}
+
+// -1 is returned if the optional arg n is omitted
+func getCountArg(ctx *C.lua_State, idx C.int) int {
+	if C.lua_type(ctx, idx) != C.LUA_TNUMBER {
+		return -1
+	}
+	return int(C.lua_tointegerx(ctx, idx, nil))
+}
+
+// regexpArgs gets the regexp at index 1 and the string at index 2.
+func regexpArgs(ctx *C.lua_State) (re *regexp.Regexp, s string, err error) {
+	if re, err = getRegexp(ctx); err != nil {
+		return
+	}
+	var ok bool
+	if s, ok = getStringArg(ctx, 2); !ok {
+		err = fmt.Errorf("bad argument #2 (string expected)")
+	}
+	return
+}
+
+//export go_re_compile
+func go_re_compile(ctx *C.lua_State) C.int {
+	// [ 1 ] pattern
+	re, err := getRegexp(ctx)
+	if err != nil {
+		return luaError(ctx, err.Error())
+	}
+	pushValueWithMetatable(ctx, re, goRegexpMeta)
+	return 1
+}
+
+//export go_re_tostring
+func go_re_tostring(ctx *C.lua_State) C.int {
+	// [ 1 ] regexp
+	re, err := getRegexp(ctx)
+	if err != nil {
+		return luaError(ctx, err.Error())
+	}
+	pushString(ctx, re.String())
+	return 1
+}
+
+//export go_re_match
+func go_re_match(ctx *C.lua_State) C.int {
+	// [ 1 ] regexp
+	// [ 2 ] s
+	re, s, err := regexpArgs(ctx)
+	if err != nil {
+		return luaError(ctx, err.Error())
+	}
+	if re.MatchString(s) {
+		C.lua_pushboolean(ctx, 1)
+	} else {
+		C.lua_pushboolean(ctx, 0)
+	}
+	return 1
+}
+
+// pushCaptures pushes the captures of a match with submatch indexes loc.
+func pushCaptures(ctx *C.lua_State, re *regexp.Regexp, s string, loc []int) {
+	names := re.SubexpNames()
+	C.lua_createtable(ctx, C.int(len(names)-1), 1) // [ caps ]
+	for i, name := range names {
+		if loc[2*i] < 0 {
+			continue // the group doesn't participate in the match
+		}
+		sub := s[loc[2*i]:loc[2*i+1]]
+		pushString(ctx, sub) // [ caps sub ]
+		C.lua_rawseti(ctx, -2, C.lua_Integer(i)) // [ caps ]
+		if len(name) > 0 {
+			pushString(ctx, name) // [ caps name ]
+			pushString(ctx, sub) // [ caps name sub ]
+			C.lua_rawset(ctx, -3) // [ caps ]
+		}
+	}
+}
+
+//export go_re_find
+func go_re_find(ctx *C.lua_State) C.int {
+	// [ 1 ] regexp
+	// [ 2 ] s
+	re, s, err := regexpArgs(ctx)
+	if err != nil {
+		return luaError(ctx, err.Error())
+	}
+	loc := re.FindStringSubmatchIndex(s)
+	if loc == nil {
+		C.lua_pushnil(ctx)
+		return 1
+	}
+	pushCaptures(ctx, re, s, loc)
+	return 1
+}
+
+//export go_re_find_all
+func go_re_find_all(ctx *C.lua_State) C.int {
+	// [ 1 ] regexp
+	// [ 2 ] s
+	// [ 3 ] n, optional
+	re, s, err := regexpArgs(ctx)
+	if err != nil {
+		return luaError(ctx, err.Error())
+	}
+	locs := re.FindAllStringSubmatchIndex(s, getCountArg(ctx, 3))
+	C.lua_createtable(ctx, C.int(len(locs)), 0) // [ ... list ]
+	for i, loc := range locs {
+		pushCaptures(ctx, re, s, loc) // [ ... list caps ]
+		C.lua_rawseti(ctx, -2, C.lua_Integer(i+1)) // [ ... list ]
+	}
+	return 1
+}
+
+//export go_re_split
+func go_re_split(ctx *C.lua_State) C.int {
+	// [ 1 ] regexp
+	// [ 2 ] s
+	// [ 3 ] n, optional
+	re, s, err := regexpArgs(ctx)
+	if err != nil {
+		return luaError(ctx, err.Error())
+	}
+	parts := re.Split(s, getCountArg(ctx, 3))
+	C.lua_createtable(ctx, C.int(len(parts)), 0) // [ ... list ]
+	for i, part := range parts {
+		pushString(ctx, part) // [ ... list part ]
+		C.lua_rawseti(ctx, -2, C.lua_Integer(i+1)) // [ ... list ]
+	}
+	return 1
+}
+
+//export go_re_gsub
+func go_re_gsub(ctx *C.lua_State) C.int {
+	// [ 1 ] regexp
+	// [ 2 ] s
+	// [ 3 ] repl
+	// [ 4 ] n, optional
+	re, s, err := regexpArgs(ctx)
+	if err != nil {
+		return luaError(ctx, err.Error())
+	}
+	replType := C.lua_type(ctx, 3)
+	var repl string
+	switch replType {
+	case C.LUA_TSTRING, C.LUA_TNUMBER:
+		repl, _ = getStringArg(ctx, 3)
+	case C.LUA_TFUNCTION:
+	default:
+		return luaError(ctx, "bad argument #3 (string or function expected)")
+	}
+
+	locs := re.FindAllStringSubmatchIndex(s, getCountArg(ctx, 4))
+	b := &strings.Builder{}
+	last := 0
+	for _, loc := range locs {
+		b.WriteString(s[last:loc[0]])
+		last = loc[1]
+		if replType != C.LUA_TFUNCTION {
+			b.Write(re.ExpandString(nil, repl, s, loc))
+			continue
+		}
+
+		// call repl(match, group1, group2, ...)
+		C.lua_pushvalue(ctx, 3) // [ ... repl ]
+		nGroups := len(loc) / 2
+		for i := 0; i < nGroups; i++ {
+			if loc[2*i] < 0 {
+				C.lua_pushnil(ctx)
+			} else {
+				pushString(ctx, s[loc[2*i]:loc[2*i+1]])
+			}
+		}
+		// [ ... repl match group1 ... ]
+		if C.lua_pcallk(ctx, C.int(nGroups), 1, 0, 0, nil) != C.LUA_OK {
+			// [ ... err ], raised again by the C wrapper
+			return -1
+		}
+		// [ ... result ]
+		switch C.lua_type(ctx, -1) {
+		case C.LUA_TNIL:
+			b.WriteString(s[loc[0]:loc[1]])
+		case C.LUA_TBOOLEAN:
+			if C.lua_toboolean(ctx, -1) != 0 {
+				return luaError(ctx, "invalid replacement value (a boolean)")
+			}
+			b.WriteString(s[loc[0]:loc[1]])
+		case C.LUA_TSTRING, C.LUA_TNUMBER:
+			r, _ := getStringArg(ctx, -1)
+			b.WriteString(r)
+		default:
+			return luaError(ctx, fmt.Sprintf("invalid replacement value (a %s)", C.GoString(C.lua_typename(ctx, C.lua_type(ctx, -1)))))
+		}
+		C.popN(ctx, 1) // [ ... ]
+	}
+	b.WriteString(s[last:])
+
+	pushString(ctx, b.String())
+	C.lua_pushinteger(ctx, C.lua_Integer(len(locs)))
+	return 2
+}
@@ -0,0 +1,42 @@
+package lua
+
+import (
+	"testing"
+)
+
+func TestRegexp(t *testing.T) {
+	ctx, err := NewContext(WithModules(RegexpModule))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.LoadScript(`
+		assert(re.match("^a+b$", "aaab") and not re.match("^a+b$", "abc"))
+
+		local m = re.find("(?P<key>\\w+)=(\\d+)", "x: k=42")
+		assert(m[0] == "k=42" and m[1] == "k" and m[2] == "42" and m.key == "k")
+		assert(re.find("z", "abc") == nil)
+
+		local all = re.find_all("\\d", "a1b2c3")
+		assert(#all == 3 and all[3][0] == "3")
+		assert(#re.find_all("\\d", "a1b2c3", 2) == 2)
+
+		local s, n = re.gsub("(\\w+)@(\\w+)", "a@b c@d", "$2@$1")
+		assert(s == "b@a d@c" and n == 2)
+		s, n = re.gsub("\\d", "a1b2", function(m) if m == "1" then return "one" end end)
+		assert(s == "aoneb2" and n == 2)
+		assert(re.gsub("\\d", "1 2 3", "x", 1) == "x 2 3")
+
+		local parts = re.split(",\\s*", "a, b,c")
+		assert(#parts == 3 and parts[2] == "b")
+
+		local r = re.compile("b+")
+		assert(r:match("abbc") and r:find("abbc")[0] == "bb")
+		assert(re.match(r, "b") and tostring(r) == "b+")
+
+		assert(not pcall(re.compile, "("))
+		assert(not pcall(re.match, "a", nil))
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
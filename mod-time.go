@@ -0,0 +1,894 @@
+package lua
+
+// #include "lua.h"
+// #include "lauxlib.h"
+// static void popN(lua_State *L, int n);
+// extern int go_time_func_wrap(lua_State *ctx);
+// extern int go_obj_free_wrap(lua_State *ctx);
+// extern int protectedToString(lua_State *L, int idx);
+import "C"
+import (
+	"math"
+	"strings"
+	"time"
+	"fmt"
+)
+
+// TimeModule is the Lua module `time` of the Go package time:
+//
+//   time.now() returns the current time
+//   time.unix(sec, nsec) returns the local time of a Unix time, sec may have fractions
+//   time.unix_milli(msec) returns the local time of a Unix time in milliseconds
+//   time.date{year=, month=, day=, hour=, min=, sec=, nsec=, loc=} returns the time of a date,
+//     month and day default to 1, others to 0, loc defaults to "Local"
+//   time.parse(layout, s, loc) parses s with a Go layout, s without a time zone is in loc,
+//     which defaults to "UTC"
+//   time.duration(d) returns the duration of a string such as "1h30m", or of nanoseconds
+//   time.since(t) returns the duration since t
+//   time.load_location(name) returns the location of an IANA name
+//   time.nanosecond ... time.hour are the units of durations
+//   time.RFC3339, time.DateTime ... are the layouts of the Go package time
+//
+// A location argument is a name such as "Asia/Shanghai" or a location returned by
+// time.load_location(), a duration argument is a duration, a string, or nanoseconds.
+//
+// Times are userdata with the methods `format`, `unix`, `unix_milli`, `unix_nano`,
+// `year`, `month`, `day`, `hour`, `minute`, `second`, `nanosecond`, `weekday`,
+// `yearday`, `location`, `in_location`, `utc`, `localtime`, `add`, `sub`, `add_date`,
+// `truncate`, `round`, `before`, `after`, `equal` and `is_zero`, and durations are
+// userdata with the methods `hours`, `minutes`, `seconds`, `milliseconds`,
+// `microseconds`, `nanoseconds`, `truncate`, `round` and `abs`. Both can be compared
+// and used in arithmetic, such as `t + 2*time.hour` or `t2 - t1`.
+//
+// time.Time values from Go are pushed as times whether or not the module is enabled,
+// and times and durations are passed back to Go as time.Time and time.Duration.
+var TimeModule = &Module{name: "time", open: openTimeModule}
+
+var (
+	goTimeMeta = "goTimeMeta\x00"
+	goDurationMeta = "goDurationMeta\x00"
+)
+
+// timeFunc is a function of the time module, it is called by go_time_func
+// with the key in timeFuncs as the upvalue.
+type timeFunc func(ctx *C.lua_State) (n int, err error)
+
+// the keys are "time.<function>" for the module, "Time.<method>" and "Duration.<method>"
+// for the methods and metamethods of times and durations.
+var timeFuncs map[string]timeFunc
+
+func init() {
+	timeFuncs = map[string]timeFunc{
+		"time.now": timeNow,
+		"time.unix": timeUnix,
+		"time.unix_milli": timeUnixMilli,
+		"time.date": timeDate,
+		"time.parse": timeParse,
+		"time.duration": timeDuration,
+		"time.since": timeSince,
+		"time.load_location": timeLoadLocation,
+
+		"Time.format": timeFormat,
+		"Time.unix": timeIntMethod(func(t time.Time) int64 { return t.Unix() }),
+		"Time.unix_milli": timeIntMethod(func(t time.Time) int64 { return t.UnixMilli() }),
+		"Time.unix_nano": timeIntMethod(func(t time.Time) int64 { return t.UnixNano() }),
+		"Time.year": timeIntMethod(func(t time.Time) int64 { return int64(t.Year()) }),
+		"Time.month": timeIntMethod(func(t time.Time) int64 { return int64(t.Month()) }),
+		"Time.day": timeIntMethod(func(t time.Time) int64 { return int64(t.Day()) }),
+		"Time.hour": timeIntMethod(func(t time.Time) int64 { return int64(t.Hour()) }),
+		"Time.minute": timeIntMethod(func(t time.Time) int64 { return int64(t.Minute()) }),
+		"Time.second": timeIntMethod(func(t time.Time) int64 { return int64(t.Second()) }),
+		"Time.nanosecond": timeIntMethod(func(t time.Time) int64 { return int64(t.Nanosecond()) }),
+		"Time.weekday": timeIntMethod(func(t time.Time) int64 { return int64(t.Weekday()) }),
+		"Time.yearday": timeIntMethod(func(t time.Time) int64 { return int64(t.YearDay()) }),
+		"Time.location": timeLocation,
+		"Time.in_location": timeInLocation,
+		"Time.utc": timeUTC,
+		"Time.localtime": timeLocal,
+		"Time.add": timeAdd,
+		"Time.sub": timeSub,
+		"Time.add_date": timeAddDate,
+		"Time.truncate": timeTruncate,
+		"Time.round": timeRound,
+		"Time.before": timeCompareMethod(time.Time.Before),
+		"Time.after": timeCompareMethod(time.Time.After),
+		"Time.equal": timeCompareMethod(time.Time.Equal),
+		"Time.is_zero": timeIsZero,
+		"Time.__tostring": timeToString,
+		"Time.__concat": timeConcat,
+		"Time.__eq": timeCompareMethod(time.Time.Equal),
+		"Time.__lt": timeCompareMethod(time.Time.Before),
+		"Time.__le": timeCompareMethod(func(t, u time.Time) bool { return !t.After(u) }),
+		"Time.__add": timeAddOp,
+		"Time.__sub": timeSub,
+
+		"Duration.hours": durationFloatMethod(time.Duration.Hours),
+		"Duration.minutes": durationFloatMethod(time.Duration.Minutes),
+		"Duration.seconds": durationFloatMethod(time.Duration.Seconds),
+		"Duration.milliseconds": durationIntMethod(time.Duration.Milliseconds),
+		"Duration.microseconds": durationIntMethod(time.Duration.Microseconds),
+		"Duration.nanoseconds": durationIntMethod(time.Duration.Nanoseconds),
+		"Duration.truncate": durationRoundMethod(time.Duration.Truncate),
+		"Duration.round": durationRoundMethod(time.Duration.Round),
+		"Duration.abs": durationAbs,
+		"Duration.__tostring": timeToString,
+		"Duration.__concat": timeConcat,
+		"Duration.__eq": durationCompare(func(a, b time.Duration) bool { return a == b }),
+		"Duration.__lt": durationCompare(func(a, b time.Duration) bool { return a < b }),
+		"Duration.__le": durationCompare(func(a, b time.Duration) bool { return a <= b }),
+		"Duration.__add": timeAddOp,
+		"Duration.__sub": durationSub,
+		"Duration.__mul": durationMul,
+		"Duration.__div": durationDiv,
+		"Duration.__unm": durationUnm,
+	}
+}
+
+var timeLayouts = map[string]string{
+	"Layout": time.Layout,
+	"ANSIC": time.ANSIC,
+	"UnixDate": time.UnixDate,
+	"RFC822": time.RFC822,
+	"RFC822Z": time.RFC822Z,
+	"RFC850": time.RFC850,
+	"RFC1123": time.RFC1123,
+	"RFC1123Z": time.RFC1123Z,
+	"RFC3339": time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"Kitchen": time.Kitchen,
+	"DateTime": time.DateTime,
+	"DateOnly": time.DateOnly,
+	"TimeOnly": time.TimeOnly,
+}
+
+var timeUnits = map[string]time.Duration{
+	"nanosecond": time.Nanosecond,
+	"microsecond": time.Microsecond,
+	"millisecond": time.Millisecond,
+	"second": time.Second,
+	"minute": time.Minute,
+	"hour": time.Hour,
+}
+
+// pushTimeFuncs sets the functions of kind to the table at the top of the stack,
+// the metamethods are set only if meta is true.
+func pushTimeFuncs(ctx *C.lua_State, kind string, meta bool) {
+	prefix := kind + "."
+	for key := range timeFuncs {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		name := key[len(prefix):]
+		if strings.HasPrefix(name, "__") != meta {
+			continue
+		}
+		pushString(ctx, name) // [ ... table name ]
+		pushString(ctx, key) // [ ... table name key ]
+		C.lua_pushcclosure(ctx, (C.lua_CFunction)(C.go_time_func_wrap), 1) // [ ... table name fn ] with key as upvalue
+		C.lua_rawset(ctx, -3) // [ ... table ]
+	}
+}
+
+// registerTimeMetatables registers the metatables of times and durations, so time.Time
+// values from Go are pushed as times even if the module is not enabled.
+func registerTimeMetatables(ctx *C.lua_State) {
+	var name *C.char
+	for kind, metaName := range map[string]string{"Time": goTimeMeta, "Duration": goDurationMeta} {
+		registerMetatable(ctx, metaName, &metaMethod{
+			name: __gc, method: (C.lua_CFunction)(C.go_obj_free_wrap),
+		})
+		getStrPtr(&metaName, &name)
+		C.lua_getfield(ctx, C.LUA_REGISTRYINDEX, name) // [ metatable ]
+		pushTimeFuncs(ctx, kind, true)
+		C.lua_createtable(ctx, 0, 0) // [ metatable methods ]
+		pushTimeFuncs(ctx, kind, false)
+		getStrPtr(&__index, &name)
+		C.lua_setfield(ctx, -2, name) // [ metatable ] with metatable.__index = methods
+		C.popN(ctx, 1) // [ ]
+	}
+}
+
+func openTimeModule(ctx *C.lua_State) {
+	C.lua_createtable(ctx, 0, 0) // [ time ]
+	pushTimeFuncs(ctx, "time", false)
+	for name, layout := range timeLayouts {
+		pushString(ctx, name) // [ time name ]
+		pushString(ctx, layout) // [ time name layout ]
+		C.lua_rawset(ctx, -3) // [ time ]
+	}
+	for name, unit := range timeUnits {
+		pushString(ctx, name) // [ time name ]
+		pushDuration(ctx, unit) // [ time name unit ]
+		C.lua_rawset(ctx, -3) // [ time ]
+	}
+}
+
+//export go_time_func
+func go_time_func(ctx *C.lua_State) C.int {
+	var length C.size_t
+	key := C.lua_tolstring(ctx, C.LUA_REGISTRYINDEX - 1, &length) // upvalue 1
+	fn, ok := timeFuncs[C.GoStringN(key, C.int(length))]
+	if !ok {
+		return luaError(ctx, "unknown function of time")
+	}
+	n, err := fn(ctx)
+	if err != nil {
+		return luaError(ctx, err.Error())
+	}
+	return C.int(n)
+}
+
+func pushTime(ctx *C.lua_State, t time.Time) {
+	pushValueWithMetatable(ctx, t, goTimeMeta)
+}
+
+func pushDuration(ctx *C.lua_State, d time.Duration) {
+	pushValueWithMetatable(ctx, d, goDurationMeta)
+}
+
+func getTime(ctx *C.lua_State, idx C.int) (t time.Time, ok bool) {
+	if C.lua_type(ctx, idx) != C.LUA_TUSERDATA {
+		return
+	}
+	if v, o := getTargetValue(ctx, idx); o {
+		t, ok = v.(time.Time)
+	}
+	return
+}
+
+func getDuration(ctx *C.lua_State, idx C.int) (d time.Duration, ok bool) {
+	if C.lua_type(ctx, idx) != C.LUA_TUSERDATA {
+		return
+	}
+	if v, o := getTargetValue(ctx, idx); o {
+		d, ok = v.(time.Duration)
+	}
+	return
+}
+
+func timeArg(ctx *C.lua_State, idx C.int) (t time.Time, err error) {
+	var ok bool
+	if t, ok = getTime(ctx, idx); !ok {
+		err = fmt.Errorf("bad argument #%d (time expected)", idx)
+	}
+	return
+}
+
+// durationArg accepts a duration, a string parsed by time.ParseDuration, or nanoseconds.
+func durationArg(ctx *C.lua_State, idx C.int) (d time.Duration, err error) {
+	switch C.lua_type(ctx, idx) {
+	case C.LUA_TUSERDATA:
+		var ok bool
+		if d, ok = getDuration(ctx, idx); ok {
+			return
+		}
+	case C.LUA_TNUMBER:
+		d = time.Duration(C.lua_tonumberx(ctx, idx, nil))
+		return
+	case C.LUA_TSTRING:
+		s, _ := getStringArg(ctx, idx)
+		if d, err = time.ParseDuration(s); err != nil {
+			err = fmt.Errorf("bad argument #%d (%v)", idx, err)
+		}
+		return
+	}
+	err = fmt.Errorf("bad argument #%d (duration expected)", idx)
+	return
+}
+
+// locationArg accepts a location name or a *time.Location, def is returned if the arg is absent.
+func locationArg(ctx *C.lua_State, idx C.int, def *time.Location) (loc *time.Location, err error) {
+	switch C.lua_type(ctx, idx) {
+	case C.LUA_TNONE, C.LUA_TNIL:
+		loc = def
+		return
+	case C.LUA_TSTRING:
+		name, _ := getStringArg(ctx, idx)
+		if loc, err = time.LoadLocation(name); err != nil {
+			err = fmt.Errorf("bad argument #%d (%v)", idx, err)
+		}
+		return
+	case C.LUA_TUSERDATA:
+		if v, ok := getTargetValue(ctx, idx); ok {
+			if loc, ok = v.(*time.Location); ok && loc != nil {
+				return
+			}
+		}
+	}
+	err = fmt.Errorf("bad argument #%d (location expected)", idx)
+	return
+}
+
+func intArg(ctx *C.lua_State, idx C.int, def int64) (i int64, err error) {
+	switch C.lua_type(ctx, idx) {
+	case C.LUA_TNONE, C.LUA_TNIL:
+		i = def
+		return
+	case C.LUA_TNUMBER:
+		if C.lua_isinteger(ctx, idx) != 0 {
+			i = int64(C.lua_tointegerx(ctx, idx, nil))
+			return
+		}
+		if f := float64(C.lua_tonumberx(ctx, idx, nil)); f == math.Trunc(f) {
+			i = int64(f)
+			return
+		}
+	}
+	err = fmt.Errorf("bad argument #%d (integer expected)", idx)
+	return
+}
+
+func timeNow(ctx *C.lua_State) (n int, err error) {
+	pushTime(ctx, time.Now())
+	return 1, nil
+}
+
+func timeUnix(ctx *C.lua_State) (n int, err error) {
+	// [ 1 ] sec
+	// [ 2 ] nsec, optional
+	if C.lua_type(ctx, 1) != C.LUA_TNUMBER {
+		err = fmt.Errorf("bad argument #1 (number expected)")
+		return
+	}
+	nsec, e := intArg(ctx, 2, 0)
+	if e != nil {
+		err = e
+		return
+	}
+	sec, frac := math.Modf(float64(C.lua_tonumberx(ctx, 1, nil)))
+	if C.lua_isinteger(ctx, 1) != 0 {
+		pushTime(ctx, time.Unix(int64(C.lua_tointegerx(ctx, 1, nil)), nsec))
+	} else {
+		pushTime(ctx, time.Unix(int64(sec), int64(frac*1e9) + nsec))
+	}
+	return 1, nil
+}
+
+func timeUnixMilli(ctx *C.lua_State) (n int, err error) {
+	// [ 1 ] msec
+	msec, e := intArg(ctx, 1, 0)
+	if e != nil {
+		err = e
+		return
+	}
+	pushTime(ctx, time.UnixMilli(msec))
+	return 1, nil
+}
+
+func timeDate(ctx *C.lua_State) (n int, err error) {
+	// [ 1 ] {year=, month=, day=, hour=, min=, sec=, nsec=, loc=}
+	if C.lua_type(ctx, 1) != C.LUA_TTABLE {
+		err = fmt.Errorf("bad argument #1 (table expected)")
+		return
+	}
+	fields := []struct{
+		name string
+		def int64
+		val int64
+	}{
+		{"year", 0, 0}, {"month", 1, 0}, {"day", 1, 0},
+		{"hour", 0, 0}, {"min", 0, 0}, {"sec", 0, 0}, {"nsec", 0, 0},
+	}
+	for i := range fields {
+		f := &fields[i]
+		pushString(ctx, f.name) // [ date name ]
+		C.lua_rawget(ctx, 1) // [ date val ]
+		f.val, err = intArg(ctx, -1, f.def)
+		C.popN(ctx, 1) // [ date ]
+		if err != nil {
+			err = fmt.Errorf("bad field %s (integer expected)", f.name)
+			return
+		}
+	}
+	pushString(ctx, "loc") // [ date "loc" ]
+	C.lua_rawget(ctx, 1) // [ date loc ]
+	loc, e := locationArg(ctx, -1, time.Local)
+	C.popN(ctx, 1) // [ date ]
+	if e != nil {
+		err = fmt.Errorf("bad field loc (location expected)")
+		return
+	}
+	t := time.Date(int(fields[0].val), time.Month(fields[1].val), int(fields[2].val),
+		int(fields[3].val), int(fields[4].val), int(fields[5].val), int(fields[6].val), loc)
+	pushTime(ctx, t)
+	return 1, nil
+}
+
+func timeParse(ctx *C.lua_State) (n int, err error) {
+	// [ 1 ] layout
+	// [ 2 ] s
+	// [ 3 ] loc, optional
+	layout, ok := getStringArg(ctx, 1)
+	if !ok {
+		err = fmt.Errorf("bad argument #1 (string expected)")
+		return
+	}
+	s, ok := getStringArg(ctx, 2)
+	if !ok {
+		err = fmt.Errorf("bad argument #2 (string expected)")
+		return
+	}
+	loc, e := locationArg(ctx, 3, time.UTC)
+	if e != nil {
+		err = e
+		return
+	}
+	t, e := time.ParseInLocation(layout, s, loc)
+	if e != nil {
+		err = e
+		return
+	}
+	pushTime(ctx, t)
+	return 1, nil
+}
+
+func timeDuration(ctx *C.lua_State) (n int, err error) {
+	// [ 1 ] d
+	d, e := durationArg(ctx, 1)
+	if e != nil {
+		err = e
+		return
+	}
+	pushDuration(ctx, d)
+	return 1, nil
+}
+
+func timeSince(ctx *C.lua_State) (n int, err error) {
+	// [ 1 ] t
+	t, e := timeArg(ctx, 1)
+	if e != nil {
+		err = e
+		return
+	}
+	pushDuration(ctx, time.Since(t))
+	return 1, nil
+}
+
+func timeLoadLocation(ctx *C.lua_State) (n int, err error) {
+	// [ 1 ] name
+	if C.lua_type(ctx, 1) != C.LUA_TSTRING {
+		err = fmt.Errorf("bad argument #1 (string expected)")
+		return
+	}
+	loc, e := locationArg(ctx, 1, nil)
+	if e != nil {
+		err = e
+		return
+	}
+	pushLuaMetaValue(ctx, loc)
+	return 1, nil
+}
+
+func timeFormat(ctx *C.lua_State) (n int, err error) {
+	// [ 1 ] t
+	// [ 2 ] layout, optional
+	t, e := timeArg(ctx, 1)
+	if e != nil {
+		err = e
+		return
+	}
+	layout := time.RFC3339
+	if C.lua_type(ctx, 2) > C.LUA_TNIL {
+		var ok bool
+		if layout, ok = getStringArg(ctx, 2); !ok {
+			err = fmt.Errorf("bad argument #2 (string expected)")
+			return
+		}
+	}
+	pushString(ctx, t.Format(layout))
+	return 1, nil
+}
+
+func timeIntMethod(fn func(time.Time) int64) timeFunc {
+	return func(ctx *C.lua_State) (n int, err error) {
+		// [ 1 ] t
+		t, e := timeArg(ctx, 1)
+		if e != nil {
+			err = e
+			return
+		}
+		C.lua_pushinteger(ctx, C.lua_Integer(fn(t)))
+		return 1, nil
+	}
+}
+
+func timeCompareMethod(fn func(time.Time, time.Time) bool) timeFunc {
+	return func(ctx *C.lua_State) (n int, err error) {
+		// [ 1 ] t
+		// [ 2 ] u
+		t, e := timeArg(ctx, 1)
+		if e != nil {
+			err = e
+			return
+		}
+		u, e := timeArg(ctx, 2)
+		if e != nil {
+			err = e
+			return
+		}
+		pushBool(ctx, fn(t, u))
+		return 1, nil
+	}
+}
+
+func timeIsZero(ctx *C.lua_State) (n int, err error) {
+	// [ 1 ] t
+	t, e := timeArg(ctx, 1)
+	if e != nil {
+		err = e
+		return
+	}
+	pushBool(ctx, t.IsZero())
+	return 1, nil
+}
+
+func timeLocation(ctx *C.lua_State) (n int, err error) {
+	// [ 1 ] t
+	t, e := timeArg(ctx, 1)
+	if e != nil {
+		err = e
+		return
+	}
+	pushString(ctx, t.Location().String())
+	return 1, nil
+}
+
+func timeInLocation(ctx *C.lua_State) (n int, err error) {
+	// [ 1 ] t
+	// [ 2 ] loc
+	t, e := timeArg(ctx, 1)
+	if e != nil {
+		err = e
+		return
+	}
+	loc, e := locationArg(ctx, 2, nil)
+	if e != nil {
+		err = e
+		return
+	}
+	if loc == nil {
+		err = fmt.Errorf("bad argument #2 (location expected)")
+		return
+	}
+	pushTime(ctx, t.In(loc))
+	return 1, nil
+}
+
+func timeUTC(ctx *C.lua_State) (n int, err error) {
+	// [ 1 ] t
+	t, e := timeArg(ctx, 1)
+	if e != nil {
+		err = e
+		return
+	}
+	pushTime(ctx, t.UTC())
+	return 1, nil
+}
+
+func timeLocal(ctx *C.lua_State) (n int, err error) {
+	// [ 1 ] t
+	t, e := timeArg(ctx, 1)
+	if e != nil {
+		err = e
+		return
+	}
+	pushTime(ctx, t.Local())
+	return 1, nil
+}
+
+func timeAdd(ctx *C.lua_State) (n int, err error) {
+	// [ 1 ] t
+	// [ 2 ] d
+	t, e := timeArg(ctx, 1)
+	if e != nil {
+		err = e
+		return
+	}
+	d, e := durationArg(ctx, 2)
+	if e != nil {
+		err = e
+		return
+	}
+	pushTime(ctx, t.Add(d))
+	return 1, nil
+}
+
+// timeSub is t - u, which is a duration, or t - d, which is a time.
+func timeSub(ctx *C.lua_State) (n int, err error) {
+	// [ 1 ] t
+	// [ 2 ] u or d
+	t, e := timeArg(ctx, 1)
+	if e != nil {
+		err = e
+		return
+	}
+	if u, ok := getTime(ctx, 2); ok {
+		pushDuration(ctx, t.Sub(u))
+		return 1, nil
+	}
+	d, e := durationArg(ctx, 2)
+	if e != nil {
+		err = fmt.Errorf("bad argument #2 (time or duration expected)")
+		return
+	}
+	pushTime(ctx, t.Add(-d))
+	return 1, nil
+}
+
+func timeAddDate(ctx *C.lua_State) (n int, err error) {
+	// [ 1 ] t
+	// [ 2 ] years
+	// [ 3 ] months, optional
+	// [ 4 ] days, optional
+	t, e := timeArg(ctx, 1)
+	if e != nil {
+		err = e
+		return
+	}
+	var ymd [3]int64
+	for i := range ymd {
+		if ymd[i], err = intArg(ctx, C.int(i+2), 0); err != nil {
+			return
+		}
+	}
+	pushTime(ctx, t.AddDate(int(ymd[0]), int(ymd[1]), int(ymd[2])))
+	return 1, nil
+}
+
+func timeTruncate(ctx *C.lua_State) (n int, err error) {
+	// [ 1 ] t
+	// [ 2 ] d
+	t, e := timeArg(ctx, 1)
+	if e != nil {
+		err = e
+		return
+	}
+	d, e := durationArg(ctx, 2)
+	if e != nil {
+		err = e
+		return
+	}
+	pushTime(ctx, t.Truncate(d))
+	return 1, nil
+}
+
+func timeRound(ctx *C.lua_State) (n int, err error) {
+	// [ 1 ] t
+	// [ 2 ] d
+	t, e := timeArg(ctx, 1)
+	if e != nil {
+		err = e
+		return
+	}
+	d, e := durationArg(ctx, 2)
+	if e != nil {
+		err = e
+		return
+	}
+	pushTime(ctx, t.Round(d))
+	return 1, nil
+}
+
+// timeToString is the __tostring of times and durations.
+func timeToString(ctx *C.lua_State) (n int, err error) {
+	// [ 1 ] t or d
+	if t, ok := getTime(ctx, 1); ok {
+		pushString(ctx, t.Format(time.RFC3339Nano))
+		return 1, nil
+	}
+	if d, ok := getDuration(ctx, 1); ok {
+		pushString(ctx, d.String())
+		return 1, nil
+	}
+	err = fmt.Errorf("bad argument #1 (time or duration expected)")
+	return
+}
+
+// timeConcat is the __concat of times and durations.
+func timeConcat(ctx *C.lua_State) (n int, err error) {
+	// [ 1 ] a
+	// [ 2 ] b
+	for i := C.int(1); i <= 2; i++ {
+		if C.protectedToString(ctx, i) != C.LUA_OK {
+			// [ a b ... err ]
+			msg, ok := getStringArg(ctx, -1)
+			if !ok {
+				msg = "error in __tostring"
+			}
+			err = fmt.Errorf("%s", msg)
+			return
+		}
+	}
+	// [ a b a-str b-str ]
+	C.lua_concat(ctx, 2) // [ a b a-str..b-str ]
+	return 1, nil
+}
+
+// timeAddOp is the __add of times and durations: t + d, d + t, or d + d.
+func timeAddOp(ctx *C.lua_State) (n int, err error) {
+	// [ 1 ] a
+	// [ 2 ] b
+	if t, ok := getTime(ctx, 1); ok {
+		d, e := durationArg(ctx, 2)
+		if e != nil {
+			err = e
+			return
+		}
+		pushTime(ctx, t.Add(d))
+		return 1, nil
+	}
+	if t, ok := getTime(ctx, 2); ok {
+		d, e := durationArg(ctx, 1)
+		if e != nil {
+			err = e
+			return
+		}
+		pushTime(ctx, t.Add(d))
+		return 1, nil
+	}
+	a, e := durationArg(ctx, 1)
+	if e != nil {
+		err = e
+		return
+	}
+	b, e := durationArg(ctx, 2)
+	if e != nil {
+		err = e
+		return
+	}
+	pushDuration(ctx, a + b)
+	return 1, nil
+}
+
+func durationOperands(ctx *C.lua_State) (a, b time.Duration, err error) {
+	// [ 1 ] a
+	// [ 2 ] b
+	if a, err = durationArg(ctx, 1); err != nil {
+		return
+	}
+	b, err = durationArg(ctx, 2)
+	return
+}
+
+func durationSub(ctx *C.lua_State) (n int, err error) {
+	a, b, e := durationOperands(ctx)
+	if e != nil {
+		err = e
+		return
+	}
+	pushDuration(ctx, a - b)
+	return 1, nil
+}
+
+func durationCompare(fn func(time.Duration, time.Duration) bool) timeFunc {
+	return func(ctx *C.lua_State) (n int, err error) {
+		a, b, e := durationOperands(ctx)
+		if e != nil {
+			err = e
+			return
+		}
+		pushBool(ctx, fn(a, b))
+		return 1, nil
+	}
+}
+
+// durationMul is d * x or x * d, where x is a number.
+func durationMul(ctx *C.lua_State) (n int, err error) {
+	// [ 1 ] a
+	// [ 2 ] b
+	dIdx, xIdx := C.int(1), C.int(2)
+	if C.lua_type(ctx, 1) == C.LUA_TNUMBER {
+		dIdx, xIdx = 2, 1
+	}
+	d, ok := getDuration(ctx, dIdx)
+	if !ok || C.lua_type(ctx, xIdx) != C.LUA_TNUMBER {
+		err = fmt.Errorf("duration can only be multiplied by a number")
+		return
+	}
+	if C.lua_isinteger(ctx, xIdx) != 0 {
+		pushDuration(ctx, d * time.Duration(C.lua_tointegerx(ctx, xIdx, nil)))
+	} else {
+		pushDuration(ctx, time.Duration(float64(d) * float64(C.lua_tonumberx(ctx, xIdx, nil))))
+	}
+	return 1, nil
+}
+
+// durationDiv is d / x, which is a duration, or d1 / d2, which is a number.
+func durationDiv(ctx *C.lua_State) (n int, err error) {
+	// [ 1 ] d
+	// [ 2 ] x or d2
+	d, ok := getDuration(ctx, 1)
+	if !ok {
+		err = fmt.Errorf("bad argument #1 (duration expected)")
+		return
+	}
+	if d2, ok := getDuration(ctx, 2); ok {
+		if d2 == 0 {
+			err = fmt.Errorf("division by zero duration")
+			return
+		}
+		C.lua_pushnumber(ctx, C.lua_Number(float64(d) / float64(d2)))
+		return 1, nil
+	}
+	if C.lua_type(ctx, 2) != C.LUA_TNUMBER {
+		err = fmt.Errorf("bad argument #2 (number or duration expected)")
+		return
+	}
+	x := float64(C.lua_tonumberx(ctx, 2, nil))
+	if x == 0 {
+		err = fmt.Errorf("division by zero")
+		return
+	}
+	pushDuration(ctx, time.Duration(float64(d) / x))
+	return 1, nil
+}
+
+func durationUnm(ctx *C.lua_State) (n int, err error) {
+	// [ 1 ] d
+	d, e := durationArg(ctx, 1)
+	if e != nil {
+		err = e
+		return
+	}
+	pushDuration(ctx, -d)
+	return 1, nil
+}
+
+func durationAbs(ctx *C.lua_State) (n int, err error) {
+	// [ 1 ] d
+	d, e := durationArg(ctx, 1)
+	if e != nil {
+		err = e
+		return
+	}
+	pushDuration(ctx, d.Abs())
+	return 1, nil
+}
+
+func durationFloatMethod(fn func(time.Duration) float64) timeFunc {
+	return func(ctx *C.lua_State) (n int, err error) {
+		// [ 1 ] d
+		d, e := durationArg(ctx, 1)
+		if e != nil {
+			err = e
+			return
+		}
+		C.lua_pushnumber(ctx, C.lua_Number(fn(d)))
+		return 1, nil
+	}
+}
+
+func durationIntMethod(fn func(time.Duration) int64) timeFunc {
+	return func(ctx *C.lua_State) (n int, err error) {
+		// [ 1 ] d
+		d, e := durationArg(ctx, 1)
+		if e != nil {
+			err = e
+			return
+		}
+		C.lua_pushinteger(ctx, C.lua_Integer(fn(d)))
+		return 1, nil
+	}
+}
+
+func durationRoundMethod(fn func(time.Duration, time.Duration) time.Duration) timeFunc {
+	return func(ctx *C.lua_State) (n int, err error) {
+		// [ 1 ] d
+		// [ 2 ] m
+		d, m, e := durationOperands(ctx)
+		if e != nil {
+			err = e
+			return
+		}
+		pushDuration(ctx, fn(d, m))
+		return 1, nil
+	}
+}
+
+func pushBool(ctx *C.lua_State, b bool) {
+	if b {
+		C.lua_pushboolean(ctx, 1)
+	} else {
+		C.lua_pushboolean(ctx, 0)
+	}
+}
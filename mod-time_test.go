@@ -0,0 +1,52 @@
+package lua
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeModule(t *testing.T) {
+	ctx, err := NewContext(WithModules(TimeModule))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.LoadScript(`
+		local t = time.date{year=2024, month=1, day=2, hour=3, min=4, sec=5, loc="UTC"}
+		assert(t:year() == 2024 and t:month() == 1 and t:day() == 2)
+		assert(t:format("2006-01-02") == "2024-01-02")
+		local d = time.duration("1m30s")
+		assert(d:seconds() == 90)
+		assert(tostring(d) == "1m30s")
+		assert((t + d):minute() == 5)
+		assert(t + d - t == d)
+		assert(t < t + d)
+		assert("d=" .. d == "d=1m30s")
+		later = t + time.hour
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := ctx.GetGlobal("later")
+	if err != nil {
+		t.Fatal(err)
+	}
+	later, ok := v.(time.Time)
+	if !ok || !later.Equal(time.Date(2024, 1, 2, 4, 4, 5, 0, time.UTC)) {
+		t.Fatalf("unexpected time: %v", v)
+	}
+}
+
+func TestTimeConcatTostringError(t *testing.T) {
+	ctx, err := NewContext(WithModules(TimeModule))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.LoadScript(`
+		local bad = setmetatable({}, {__tostring=function() error("x") end})
+		local ok, err = pcall(function() return time.second .. bad end)
+		assert(not ok and err:find("x"))
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,59 @@
+package lua
+
+// #include "lua.h"
+// #include "lauxlib.h"
+// static void popN(lua_State *L, int n);
+import "C"
+
+// Module is an optional Lua module enabled by WithModules(). An enabled module is
+// a global of its name, and can also be loaded by `require`.
+type Module struct {
+	name string
+	open func(ctx *C.lua_State) // pushes the table of the module
+}
+
+// WithModules enables the optional Lua modules, such as JSONModule.
+func WithModules(modules ...*Module) Option {
+	return func(o *options) {
+		o.modules = append(o.modules, modules...)
+	}
+}
+
+func openModules(ctx *C.lua_State) {
+	env := getCtxEnv(ctx)
+	if env == nil {
+		return
+	}
+	for _, m := range env.opts.modules {
+		name := m.name + "\x00"
+		var cName *C.char
+		getStrPtr(&name, &cName)
+
+		m.open(ctx) // [ module ]
+		C.lua_pushvalue(ctx, -1) // [ module module ]
+		C.lua_setglobal(ctx, cName) // [ module ]
+
+		loaded := "_LOADED\x00"
+		var cLoaded *C.char
+		getStrPtr(&loaded, &cLoaded)
+		C.luaL_getsubtable(ctx, C.LUA_REGISTRYINDEX, cLoaded) // [ module loaded ]
+		C.lua_rotate(ctx, -2, 1) // [ loaded module ]
+		C.lua_setfield(ctx, -2, cName) // [ loaded ] with loaded[name] = module
+		C.popN(ctx, 1) // [ ]
+	}
+}
+
+type moduleFunc struct {
+	name string
+	fn C.lua_CFunction
+}
+
+// newModuleTable pushes a table with the functions of a module.
+func newModuleTable(ctx *C.lua_State, funcs ...moduleFunc) {
+	C.lua_createtable(ctx, 0, C.int(len(funcs))) // [ module ]
+	for _, f := range funcs {
+		pushString(ctx, f.name) // [ module name ]
+		C.lua_pushcclosure(ctx, f.fn, 0) // [ module name fn ]
+		C.lua_rawset(ctx, -3) // [ module ]
+	}
+}
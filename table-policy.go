@@ -0,0 +1,267 @@
+package lua
+
+// #include "lua.h"
+// static void popN(lua_State *L, int n);
+import "C"
+import (
+	"fmt"
+)
+
+// EmptyTablePolicy decides what an empty Lua table is converted to.
+type EmptyTablePolicy int
+const (
+	EmptyTableAsNil EmptyTablePolicy = iota // default, as former versions did
+	EmptyTableAsMap
+	EmptyTableAsSlice
+)
+
+// SparseArrayPolicy decides what a table with positive integer keys and holes is converted to.
+type SparseArrayPolicy int
+const (
+	SparseArrayAsMap SparseArrayPolicy = iota // default, integer keys are converted to strings
+	SparseArrayAsSlice // holes are filled with nil
+)
+
+// MixedTablePolicy decides what a table with both integer and string keys is converted to.
+type MixedTablePolicy int
+const (
+	MixedTableAsMap MixedTablePolicy = iota // default, integer keys are converted to strings
+	MixedTableAsError
+	MixedTableAsInterfaceMap // map[interface{}]interface{} with int64 and string keys
+)
+
+// TablePolicy is the policy converting Lua tables to Go values. The zero value
+// converts tables just as the former versions did, except that a table with keys
+// 1..n is always converted to a slice, whatever the order of its keys is.
+//
+// A table with keys other than integers and strings, such as booleans, floats or
+// tables, is converted to map[interface{}]interface{}, in which integer keys are int64,
+// table keys are *LuaTable and function keys are *LuaFunction.
+//
+// A table marked as array from Lua by `array(t)` is always converted to a slice.
+type TablePolicy struct {
+	EmptyTable  EmptyTablePolicy
+	SparseArray SparseArrayPolicy
+	// max number of holes filled with nil when a sparse array is converted to a slice,
+	// 0 means as many as the elements of the array.
+	MaxHoles    int
+	MixedTable  MixedTablePolicy
+
+	// limits against hostile scripts, 0 means no limit. A table referenced more than
+	// once is converted only once, and a table containing itself can't be converted.
+	MaxDepth    int // max nesting depth of tables
+	MaxElements int // max number of elements of all the nested tables
+}
+
+const (
+	arrayFlag = "__array"
+
+	// array(t) marks t as an array by setting a metatable with `__array`. A table with
+	// another metatable is refused, as the metatable may be shared by other tables.
+	arrayHelper = `
+local arrayMeta = {__array = true}
+function array(t)
+	t = t or {}
+	local mt = getmetatable(t)
+	if mt == nil then
+		return setmetatable(t, arrayMeta)
+	end
+	if type(mt) ~= "table" or rawget(mt, "__array") ~= true then
+		error("bad argument #1 to 'array' (table with a metatable)", 2)
+	end
+	return t
+end
+`
+)
+
+// SetTablePolicy changes the policy of converting Lua tables to Go values.
+func (ctx *LuaContext) SetTablePolicy(policy TablePolicy) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	ctx.opts.tablePolicy = policy
+}
+
+func getTablePolicy(ctx *C.lua_State) (policy *TablePolicy) {
+	if env := getCtxEnv(ctx); env != nil {
+		return &env.opts.tablePolicy
+	}
+	return &TablePolicy{}
+}
+
+func isArrayTable(ctx *C.lua_State) (isArray bool) {
+	// [ ... table ]
+	if C.lua_getmetatable(ctx, -1) == 0 {
+		return
+	}
+	// [ ... table metatable ]
+	pushString(ctx, arrayFlag) // [ ... table metatable "__array" ]
+	C.lua_rawget(ctx, -2) // [ ... table metatable flag ]
+	isArray = C.lua_toboolean(ctx, -1) != 0
+	C.popN(ctx, 2) // [ ... table ]
+	return
+}
+
+// tableItems are the items of a Lua table collected by fromLuaTable()
+type tableItems struct {
+	arr    []interface{}           // values of keys 1..n in order
+	sparse map[int64]interface{}   // values of the other positive integer keys
+	maxIdx int64
+	others map[string]interface{}  // values of the string keys
+	firstKey string                // first key not an index, for reporting
+	ints   map[int64]interface{}   // values of the non-positive integer keys
+	anys   map[interface{}]interface{} // values of the keys neither integers nor strings
+}
+
+func (items *tableItems) addIndex(idx int64, val interface{}) {
+	if idx == int64(len(items.arr)) + 1 {
+		items.arr = append(items.arr, val)
+	} else {
+		if items.sparse == nil {
+			items.sparse = make(map[int64]interface{})
+		}
+		items.sparse[idx] = val
+	}
+	if idx > items.maxIdx {
+		items.maxIdx = idx
+	}
+}
+
+func (items *tableItems) addKey(key string, val interface{}) {
+	if items.others == nil {
+		items.others = make(map[string]interface{})
+	}
+	items.others[key] = val
+	items.keyFound(key)
+}
+
+func (items *tableItems) addInt(key int64, val interface{}) {
+	if items.ints == nil {
+		items.ints = make(map[int64]interface{})
+	}
+	items.ints[key] = val
+	items.keyFound(fmt.Sprintf("%d", key))
+}
+
+func (items *tableItems) addAny(key interface{}, val interface{}) {
+	if items.anys == nil {
+		items.anys = make(map[interface{}]interface{})
+	}
+	items.anys[key] = val
+	items.keyFound(fmt.Sprintf("%v", key))
+}
+
+func (items *tableItems) keyFound(key string) {
+	if len(items.firstKey) == 0 {
+		items.firstKey = key
+	}
+}
+
+func (items *tableItems) toValue(policy *TablePolicy, isArray bool) (goVal interface{}, err error) {
+	nIdx := len(items.arr) + len(items.sparse)
+	nOthers := len(items.others) + len(items.ints) + len(items.anys)
+
+	switch {
+	case nIdx == 0 && nOthers == 0:
+		if isArray {
+			goVal = []interface{}{}
+			return
+		}
+		switch policy.EmptyTable {
+		case EmptyTableAsMap:
+			goVal = map[string]interface{}{}
+		case EmptyTableAsSlice:
+			goVal = []interface{}{}
+		}
+		return
+	case nOthers == 0:
+		holes := items.maxIdx - int64(nIdx)
+		if holes == 0 {
+			goVal = items.toSlice()
+			return
+		}
+		maxHoles := int64(policy.MaxHoles)
+		if maxHoles <= 0 {
+			maxHoles = int64(nIdx)
+		}
+		if isArray || policy.SparseArray == SparseArrayAsSlice {
+			if holes > maxHoles {
+				err = fmt.Errorf("too many holes (%d) in sparse array", holes)
+				return
+			}
+			goVal = items.toSlice()
+			return
+		}
+		goVal = items.toMap()
+		return
+	default:
+		if isArray {
+			err = fmt.Errorf("key %q found in table marked as array", items.firstKey)
+			return
+		}
+		if len(items.anys) > 0 {
+			goVal = items.toInterfaceMap()
+			return
+		}
+		if nIdx + len(items.ints) > 0 && len(items.others) > 0 {
+			switch policy.MixedTable {
+			case MixedTableAsError:
+				err = fmt.Errorf("table with both integer keys and key %q can't be converted", items.firstKey)
+				return
+			case MixedTableAsInterfaceMap:
+				goVal = items.toInterfaceMap()
+				return
+			}
+		}
+		goVal = items.toMap()
+		return
+	}
+}
+
+func (items *tableItems) toSlice() []interface{} {
+	if len(items.sparse) == 0 {
+		return items.arr
+	}
+	arr := make([]interface{}, items.maxIdx)
+	copy(arr, items.arr)
+	for idx, val := range items.sparse {
+		arr[idx-1] = val
+	}
+	return arr
+}
+
+func (items *tableItems) toMap() map[string]interface{} {
+	res := items.others
+	if res == nil {
+		res = make(map[string]interface{})
+	}
+	for i, val := range items.arr {
+		res[fmt.Sprintf("%d", i+1)] = val
+	}
+	for idx, val := range items.sparse {
+		res[fmt.Sprintf("%d", idx)] = val
+	}
+	for key, val := range items.ints {
+		res[fmt.Sprintf("%d", key)] = val
+	}
+	return res
+}
+
+func (items *tableItems) toInterfaceMap() map[interface{}]interface{} {
+	res := items.anys
+	if res == nil {
+		res = make(map[interface{}]interface{})
+	}
+	for key, val := range items.others {
+		res[key] = val
+	}
+	for i, val := range items.arr {
+		res[int64(i+1)] = val
+	}
+	for idx, val := range items.sparse {
+		res[idx] = val
+	}
+	for key, val := range items.ints {
+		res[key] = val
+	}
+	return res
+}
@@ -0,0 +1,82 @@
+package lua
+
+import (
+	"reflect"
+	"testing"
+)
+
+func getGlobalOf(t *testing.T, ctx *LuaContext, script string) (interface{}, error) {
+	if err := ctx.LoadScript("v = " + script, nil); err != nil {
+		t.Fatal(err)
+	}
+	return ctx.GetGlobal("v")
+}
+
+func TestTablePolicy(t *testing.T) {
+	for _, c := range []struct{
+		policy TablePolicy
+		script string
+		want interface{}
+	}{
+		{TablePolicy{}, `{}`, nil},
+		{TablePolicy{EmptyTable: EmptyTableAsMap}, `{}`, map[string]interface{}{}},
+		{TablePolicy{EmptyTable: EmptyTableAsSlice}, `{}`, []interface{}{}},
+		{TablePolicy{}, `array()`, []interface{}{}},
+		{TablePolicy{}, `{[2] = "b", [1] = "a"}`, []interface{}{"a", "b"}},
+		{TablePolicy{}, `{[1] = "a", [3] = "c"}`, map[string]interface{}{"1": "a", "3": "c"}},
+		{TablePolicy{SparseArray: SparseArrayAsSlice}, `{[1] = "a", [3] = "c"}`, []interface{}{"a", nil, "c"}},
+		{TablePolicy{}, `{"a", x = 1}`, map[string]interface{}{"1": "a", "x": 1.0}},
+		{TablePolicy{MixedTable: MixedTableAsInterfaceMap}, `{"a", x = 1}`, map[interface{}]interface{}{int64(1): "a", "x": 1.0}},
+	} {
+		ctx, err := NewContext(WithTablePolicy(c.policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := getGlobalOf(t, ctx, c.script)
+		if err != nil {
+			t.Fatalf("%s: %v", c.script, err)
+		}
+		if !reflect.DeepEqual(v, c.want) {
+			t.Fatalf("%s with %+v: %#v expected, got %#v", c.script, c.policy, c.want, v)
+		}
+	}
+}
+
+func TestTablePolicyErrors(t *testing.T) {
+	for _, c := range []struct{
+		policy TablePolicy
+		script string
+	}{
+		{TablePolicy{MixedTable: MixedTableAsError}, `{"a", x = 1}`},
+		{TablePolicy{SparseArray: SparseArrayAsSlice, MaxHoles: 1}, `{[1] = "a", [4] = "d"}`},
+	} {
+		ctx, err := NewContext(WithTablePolicy(c.policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = getGlobalOf(t, ctx, c.script); err == nil {
+			t.Fatalf("%s: error expected", c.script)
+		}
+	}
+}
+
+func TestArrayHelper(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.LoadScript(`
+		local shared = {}
+		local a = setmetatable({}, shared)
+		local ok, err = pcall(array, a)
+		assert(not ok and err:find("metatable"))
+		assert(rawget(shared, "__array") == nil)
+		assert(not pcall(array, setmetatable({}, {__metatable = "locked"})))
+
+		local t = array({1})
+		assert(array(t) == t)
+	`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
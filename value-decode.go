@@ -0,0 +1,368 @@
+package lua
+
+// #include "lua.h"
+// static void popN(lua_State *L, int n);
+import "C"
+import (
+	elutils "github.com/rosbit/go-embedding-utils"
+	"reflect"
+	"strings"
+	"unsafe"
+	"fmt"
+)
+
+// valueDecoder decodes a Lua value into a Go value of a given type, tables are decoded
+// recursively into structs, slices, arrays, maps and pointers of them.
+type valueDecoder struct {
+	visiting map[unsafe.Pointer]bool
+	unknownFields UnknownFieldPolicy // keys of tables not found in structs
+}
+
+// decodeLuaValue decodes the value at the top of the stack into dest, name is the
+// name of dest used in errors.
+func decodeLuaValue(ctx *C.lua_State, dest reflect.Value, name string) error {
+	d := &valueDecoder{visiting: make(map[unsafe.Pointer]bool), unknownFields: getUnknownFieldPolicy(ctx)}
+	return d.decode(ctx, dest, name)
+}
+
+func (d *valueDecoder) decode(ctx *C.lua_State, dest reflect.Value, path string) (err error) {
+	// [ ... value ]
+	dt := dest.Type()
+	luaType := C.lua_type(ctx, -1)
+
+	switch dt {
+	case luaTableType, luaFunctionType, luaThreadType:
+		goVal, e := fromLuaValueAs(ctx, dt)
+		if e != nil {
+			return fmt.Errorf("%s: %v", path, e)
+		}
+		if goVal == nil && luaType != C.LUA_TNIL {
+			return d.mismatch(ctx, dt, path)
+		}
+		return elutils.SetValue(dest, goVal)
+	}
+
+	switch luaType {
+	case C.LUA_TNIL, C.LUA_TNONE:
+		dest.Set(reflect.Zero(dt))
+		return
+	case C.LUA_TUSERDATA:
+		if v, ok := getTargetValue(ctx, -1); ok {
+			if m, ok := v.(*goMethod); ok {
+				v = m.fn.Interface()
+			}
+			if v != nil && d.assignGoValue(dest, reflect.ValueOf(v)) {
+				return
+			}
+		}
+		if dt.Kind() != reflect.Interface || dt.NumMethod() > 0 {
+			return d.mismatch(ctx, dt, path)
+		}
+	}
+
+	switch dt.Kind() {
+	case reflect.Bool:
+		if luaType != C.LUA_TBOOLEAN {
+			return d.mismatch(ctx, dt, path)
+		}
+		dest.SetBool(C.lua_toboolean(ctx, -1) != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := d.toInteger(ctx)
+		if !ok {
+			return d.mismatch(ctx, dt, path)
+		}
+		if dest.OverflowInt(i) {
+			return fmt.Errorf("%s: %d overflows %s", path, i, dt)
+		}
+		dest.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i, ok := d.toInteger(ctx)
+		if !ok {
+			return d.mismatch(ctx, dt, path)
+		}
+		if i < 0 || dest.OverflowUint(uint64(i)) {
+			return fmt.Errorf("%s: %d overflows %s", path, i, dt)
+		}
+		dest.SetUint(uint64(i))
+	case reflect.Float32, reflect.Float64:
+		if luaType != C.LUA_TNUMBER {
+			return d.mismatch(ctx, dt, path)
+		}
+		dest.SetFloat(float64(C.lua_tonumberx(ctx, -1, (*C.int)(unsafe.Pointer(nil)))))
+	case reflect.String:
+		if luaType != C.LUA_TSTRING {
+			return d.mismatch(ctx, dt, path)
+		}
+		var length C.size_t
+		s := C.lua_tolstring(ctx, -1, &length)
+		dest.SetString(C.GoStringN(s, C.int(length)))
+	case reflect.Slice:
+		if isBytesType(dt) && luaType == C.LUA_TSTRING {
+			var length C.size_t
+			s := C.lua_tolstring(ctx, -1, &length)
+			dest.Set(reflect.ValueOf(C.GoBytes(unsafe.Pointer(s), C.int(length))).Convert(dt))
+			return
+		}
+		if luaType != C.LUA_TTABLE {
+			return d.mismatch(ctx, dt, path)
+		}
+		n := int(C.lua_rawlen(ctx, -1))
+		arr := reflect.MakeSlice(dt, n, n)
+		if err = d.decodeArray(ctx, arr, path); err != nil {
+			return
+		}
+		dest.Set(arr)
+	case reflect.Array:
+		if luaType != C.LUA_TTABLE {
+			return d.mismatch(ctx, dt, path)
+		}
+		if n := int(C.lua_rawlen(ctx, -1)); n > dt.Len() {
+			return fmt.Errorf("%s: %d elements can't be stored in %s", path, n, dt)
+		}
+		arr := reflect.New(dt).Elem()
+		if err = d.decodeArray(ctx, arr, path); err != nil {
+			return
+		}
+		dest.Set(arr)
+	case reflect.Map:
+		if luaType != C.LUA_TTABLE {
+			return d.mismatch(ctx, dt, path)
+		}
+		m := reflect.MakeMap(dt)
+		if err = d.decodeMap(ctx, m, path); err != nil {
+			return
+		}
+		dest.Set(m)
+	case reflect.Struct:
+		if luaType != C.LUA_TTABLE {
+			return d.mismatch(ctx, dt, path)
+		}
+		st := reflect.New(dt).Elem()
+		if err = d.decodeStruct(ctx, st, path); err != nil {
+			return
+		}
+		dest.Set(st)
+	case reflect.Ptr:
+		p := reflect.New(dt.Elem())
+		if err = d.decode(ctx, p.Elem(), path); err != nil {
+			return
+		}
+		dest.Set(p)
+	case reflect.Func:
+		if luaType != C.LUA_TFUNCTION {
+			return d.mismatch(ctx, dt, path)
+		}
+		helper, e := elutils.NewEmbeddingFuncHelper(reflect.New(dt).Interface())
+		if e != nil {
+			return fmt.Errorf("%s: %v", path, e)
+		}
+		f := newLuaFunction(ctx)
+		dest.Set(reflect.MakeFunc(dt, f.wrap(helper)))
+	case reflect.Interface:
+		if dt.NumMethod() > 0 {
+			return d.mismatch(ctx, dt, path)
+		}
+		goVal, e := fromLuaValue(ctx)
+		if e != nil {
+			return fmt.Errorf("%s: %v", path, e)
+		}
+		if s, ok := goVal.(string); ok {
+			goVal = strings.Clone(s)
+		}
+		if goVal == nil {
+			dest.Set(reflect.Zero(dt))
+		} else {
+			dest.Set(reflect.ValueOf(goVal))
+		}
+	default:
+		return d.mismatch(ctx, dt, path)
+	}
+	return
+}
+
+// assignGoValue assigns a Go value from a proxy to dest, the value is dereferenced or
+// copied to a new pointer if needed.
+func (d *valueDecoder) assignGoValue(dest, v reflect.Value) bool {
+	dt := dest.Type()
+	if v.Type().AssignableTo(dt) {
+		dest.Set(v)
+		return true
+	}
+	if v.Kind() == reflect.Ptr && !v.IsNil() && v.Elem().Type().AssignableTo(dt) {
+		dest.Set(v.Elem())
+		return true
+	}
+	if dt.Kind() == reflect.Ptr && v.Type().AssignableTo(dt.Elem()) {
+		p := reflect.New(dt.Elem())
+		p.Elem().Set(v)
+		dest.Set(p)
+		return true
+	}
+	return false
+}
+
+// integers and floats with integral values are accepted as integers
+func (d *valueDecoder) toInteger(ctx *C.lua_State) (i int64, ok bool) {
+	if C.lua_type(ctx, -1) != C.LUA_TNUMBER {
+		return
+	}
+	var isNum C.int
+	i = int64(C.lua_tointegerx(ctx, -1, &isNum))
+	ok = isNum != 0
+	return
+}
+
+func (d *valueDecoder) mismatch(ctx *C.lua_State, dt reflect.Type, path string) error {
+	typeName := C.GoString(C.lua_typename(ctx, C.lua_type(ctx, -1)))
+	if C.lua_type(ctx, -1) == C.LUA_TNUMBER && C.lua_isinteger(ctx, -1) == 0 && isIntegerKind(dt.Kind()) {
+		typeName = "non-integral number"
+	}
+	return fmt.Errorf("%s: cannot use %s as %s", path, typeName, dt)
+}
+
+func isIntegerKind(k reflect.Kind) bool {
+	return isNumberKind(k) && k != reflect.Float32 && k != reflect.Float64
+}
+
+// enter checks cycles of the table at the top of the stack, leave must be called if ok.
+func (d *valueDecoder) enter(ctx *C.lua_State, path string) (leave func(), err error) {
+	p := C.lua_topointer(ctx, -1)
+	if d.visiting[p] {
+		err = fmt.Errorf("%s: cycle found", path)
+		return
+	}
+	d.visiting[p] = true
+	leave = func() {
+		delete(d.visiting, p)
+	}
+	return
+}
+
+func (d *valueDecoder) decodeArray(ctx *C.lua_State, arr reflect.Value, path string) (err error) {
+	// [ ... table ]
+	leave, err := d.enter(ctx, path)
+	if err != nil {
+		return
+	}
+	defer leave()
+
+	n := int(C.lua_rawlen(ctx, -1))
+	for i := 0; i < n; i++ {
+		C.lua_rawgeti(ctx, -1, C.lua_Integer(i+1)) // [ ... table elem ]
+		err = d.decode(ctx, arr.Index(i), fmt.Sprintf("%s[%d]", path, i+1))
+		C.popN(ctx, 1) // [ ... table ]
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (d *valueDecoder) decodeMap(ctx *C.lua_State, m reflect.Value, path string) (err error) {
+	// [ ... table ]
+	leave, err := d.enter(ctx, path)
+	if err != nil {
+		return
+	}
+	defer leave()
+
+	mt := m.Type()
+	C.lua_pushnil(ctx) // [ ... table nil ]
+	for C.lua_next(ctx, -2) != 0 {
+		// [ ... table key val ]
+		key := reflect.New(mt.Key()).Elem()
+		C.lua_pushvalue(ctx, -2) // [ ... table key val key ]
+		err = d.decode(ctx, key, path + "[key]")
+		C.popN(ctx, 1) // [ ... table key val ]
+		if err == nil && !key.Type().Comparable() {
+			err = fmt.Errorf("%s: key of %s is not comparable", path, key.Type())
+		}
+		if err != nil {
+			C.popN(ctx, 2) // [ ... table ]
+			return
+		}
+
+		val := reflect.New(mt.Elem()).Elem()
+		if err = d.decode(ctx, val, fmt.Sprintf("%s[%v]", path, key.Interface())); err != nil {
+			C.popN(ctx, 2) // [ ... table ]
+			return
+		}
+		C.popN(ctx, 1) // [ ... table key ]
+		m.SetMapIndex(key, val)
+	}
+	return
+}
+
+func (d *valueDecoder) decodeStruct(ctx *C.lua_State, st reflect.Value, path string) (err error) {
+	// [ ... table ]
+	leave, err := d.enter(ctx, path)
+	if err != nil {
+		return
+	}
+	defer leave()
+
+	C.lua_pushnil(ctx) // [ ... table nil ]
+	for C.lua_next(ctx, -2) != 0 {
+		// [ ... table key val ]
+		if C.lua_type(ctx, -2) != C.LUA_TSTRING {
+			if d.unknownFields == UnknownFieldAsError {
+				err = fmt.Errorf("%s: %s key found", path, C.GoString(C.lua_typename(ctx, C.lua_type(ctx, -2))))
+				C.popN(ctx, 2) // [ ... table ]
+				return
+			}
+			C.popN(ctx, 1) // [ ... table key ]
+			continue
+		}
+		var length C.size_t
+		s := C.lua_tolstring(ctx, -2, &length)
+		key := C.GoStringN(s, C.int(length))
+
+		sf, fv, found := luaStructField(st, key, true)
+		if !found {
+			if d.unknownFields == UnknownFieldAsError {
+				err = fmt.Errorf("%s.%s: field not found", path, key)
+				C.popN(ctx, 2) // [ ... table ]
+				return
+			}
+			C.popN(ctx, 1) // [ ... table key ]
+			continue
+		}
+		if isReadOnlyField(sf) {
+			C.popN(ctx, 2) // [ ... table ]
+			err = fmt.Errorf("%s.%s: field is read-only", path, key)
+			return
+		}
+		if !fv.IsValid() || !fv.CanSet() {
+			C.popN(ctx, 2) // [ ... table ]
+			err = fmt.Errorf("%s.%s: field cannot be set", path, key)
+			return
+		}
+		if err = d.decode(ctx, fv, path + "." + key); err != nil {
+			C.popN(ctx, 2) // [ ... table ]
+			return
+		}
+		C.popN(ctx, 1) // [ ... table key ]
+	}
+	return
+}
+
+// luaStructField finds the field of a struct by the name in tag `lua:"name"`, or by
+// the name with the first letter in upper case, see structField().
+func luaStructField(st reflect.Value, key string, alloc bool) (sf reflect.StructField, fv reflect.Value, found bool) {
+	t := st.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if name, _, _ := strings.Cut(f.Tag.Get("lua"), ","); len(name) > 0 && name == key {
+			return f, st.Field(i), true
+		}
+	}
+	if len(key) == 0 {
+		return
+	}
+	name := upperFirst(key)
+	if sf, found = t.FieldByName(name); !found {
+		return
+	}
+	fv, found = structField(st, name, alloc)
+	return
+}
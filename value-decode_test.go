@@ -0,0 +1,97 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+)
+
+type testNode struct {
+	Host string
+	Port int
+}
+
+type testNodes struct {
+	Name    string
+	Servers []testNode
+	Tags    map[string]int
+	Primary *testNode
+	Check   func(int) bool
+}
+
+func TestDecodeTable(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &testNodes{}
+	err = ctx.LoadScript(`
+		c.servers = { {host = "a", port = 1}, {host = "b", port = 2} }
+		c.tags = {x = 1}
+		c.primary = {host = "p", port = 3}
+		c.check = function(n) return n > 0 end
+	`, map[string]interface{}{"c": c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Servers) != 2 || c.Servers[1].Host != "b" || c.Servers[1].Port != 2 {
+		t.Fatalf("unexpected servers %+v", c.Servers)
+	}
+	if c.Tags["x"] != 1 || c.Primary == nil || c.Primary.Port != 3 {
+		t.Fatalf("unexpected cluster %+v", c)
+	}
+	if c.Check == nil || !c.Check(1) || c.Check(0) {
+		t.Fatal("unexpected check function")
+	}
+}
+
+func TestDecodeTableMismatch(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]interface{}{"c": &testNodes{}}
+	for script, msg := range map[string]string{
+		`c.servers = { {host = "a", port = "x"} }`: "cannot use string as int",
+		`c.servers = { {host = "a", port = 1.5} }`: "non-integral number",
+		`c.servers = { {host = "a", weight = 1} }`: "weight: field not found",
+		`c.primary = {"a"}`: "number key found",
+	} {
+		err = ctx.LoadScript(script, env)
+		if err == nil || !strings.Contains(err.Error(), msg) {
+			t.Fatalf("%s: unexpected error %v", script, err)
+		}
+	}
+}
+
+func TestDecodeTableUnknownFields(t *testing.T) {
+	for _, policy := range []UnknownFieldPolicy{UnknownFieldIgnore, UnknownFieldStore} {
+		ctx, err := NewContext(WithUnknownFields(policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := &testNodes{}
+		err = ctx.LoadScript(`c.primary = {host = "a", weight = 1, "x"}`, map[string]interface{}{"c": c})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Primary == nil || c.Primary.Host != "a" {
+			t.Fatalf("unexpected primary %+v", c.Primary)
+		}
+	}
+}
+
+type testKeyring struct {
+	Secrets []testSecret
+}
+
+func TestDecodeReadOnlyField(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := &testKeyring{}
+	err = ctx.LoadScript(`k.secrets = { {key = "k"} }`, map[string]interface{}{"k": k})
+	if err == nil || !strings.Contains(err.Error(), "[1].key: field is read-only") || len(k.Secrets) != 0 {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -4,12 +4,18 @@ package lua
 // static void popN(lua_State *L, int n);
 import "C"
 import (
-	// "reflect"
+	"reflect"
+	"strings"
 	"unsafe"
 	"fmt"
 )
 
 func fromLuaValue(ctx *C.lua_State) (goVal interface{}, err error) {
+	return convertLuaValue(ctx, nil)
+}
+
+// cv is created when the first table is met, and is shared by all the nested values.
+func convertLuaValue(ctx *C.lua_State, cv *tableConverter) (goVal interface{}, err error) {
 	var length C.size_t
 
 	switch (C.lua_type(ctx, -1)) {
@@ -27,7 +33,10 @@ func fromLuaValue(ctx *C.lua_State) (goVal interface{}, err error) {
 		// goVal = C.GoStringN(s, C.int(length))
 		return
 	case C.LUA_TTABLE:
-		return fromLuaTable(ctx)
+		if cv == nil {
+			cv = newTableConverter(ctx)
+		}
+		return cv.fromLuaTable(ctx)
 	case C.LUA_TFUNCTION:
 		goVal = fromLuaFunc(ctx)
 		return
@@ -37,9 +46,15 @@ func fromLuaValue(ctx *C.lua_State) (goVal interface{}, err error) {
 			err = fmt.Errorf("target not found")
 			return
 		}
+		if m, ok := targetV.(*goMethod); ok {
+			goVal = m.fn.Interface()
+			return
+		}
 		goVal = targetV
 		return
-	// case LUA_TTHREAD:
+	case C.LUA_TTHREAD:
+		goVal = newLuaThread(ctx)
+		return
 	case C.LUA_TLIGHTUSERDATA:
 		goVal = (unsafe.Pointer)(C.lua_touserdata(ctx, -1))
 		return
@@ -49,41 +64,67 @@ func fromLuaValue(ctx *C.lua_State) (goVal interface{}, err error) {
 	}
 }
 
-func fromLuaTable(ctx *C.lua_State) (goVal interface{}, err error) {
+// tableConverter converts nested tables, it detects cycles and checks the limits of the policy.
+type tableConverter struct {
+	policy *TablePolicy
+	path []interface{} // keys from the root to the table being converted
+	visiting map[unsafe.Pointer]bool
+	converted map[unsafe.Pointer]interface{}
+	elements int
+}
+
+func newTableConverter(ctx *C.lua_State) *tableConverter {
+	return &tableConverter{
+		policy: getTablePolicy(ctx),
+		visiting: make(map[unsafe.Pointer]bool),
+		converted: make(map[unsafe.Pointer]interface{}),
+	}
+}
+
+func (cv *tableConverter) fromLuaTable(ctx *C.lua_State) (goVal interface{}, err error) {
 	// [ ... table ]
-	res := make(map[string]interface{})
-	arr := []interface{}{}
-	i := 1
-	maybeArr := true
+	p := C.lua_topointer(ctx, -1)
+	if v, ok := cv.converted[p]; ok {
+		// a table referenced more than once is converted to the same value.
+		goVal = v
+		return
+	}
+	if cv.visiting[p] {
+		err = fmt.Errorf("cycle found at %s", cv.pathString())
+		return
+	}
+	if maxDepth := cv.policy.MaxDepth; maxDepth > 0 && len(cv.path) >= maxDepth {
+		err = fmt.Errorf("max depth %d exceeded at %s", maxDepth, cv.pathString())
+		return
+	}
+	cv.visiting[p] = true
+	defer delete(cv.visiting, p)
 
-	var val interface{}
+	isArray := isArrayTable(ctx)
+	items := &tableItems{}
+
+	var key, val interface{}
 	C.lua_pushnil(ctx) // [ ... table nil ]
 	for C.lua_next(ctx, -2) != 0 {
 		// [ ... table key val ]
-		if maybeArr {
-			if C.lua_isinteger(ctx, -2) != 0 {
-				idx := int(C.lua_tointegerx(ctx, -2, (*C.int)(unsafe.Pointer(nil))))
-				if idx != i {
-					goto FOR_MAP
-				}
-				if val, err = fromLuaValue(ctx); err != nil {
-					C.popN(ctx, 2) // [ ... table ]
-					return
-				}
-				if _, ok := val.(string); ok {
-					fmt.Sprintf("%s", val) // deep copy
-				}
-				arr = append(arr, val)
-				res[fmt.Sprintf("%d", idx)] = val // also save to map
-				C.popN(ctx, 1) // [ ... table key ]
-				i += 1
-				continue
-			}
+		cv.elements += 1
+		if maxElements := cv.policy.MaxElements; maxElements > 0 && cv.elements > maxElements {
+			err = fmt.Errorf("max elements %d exceeded at %s", maxElements, cv.pathString())
+			C.popN(ctx, 2) // [ ... talbe ]
+			return
 		}
-FOR_MAP:
-		maybeArr = false
-		// [ ... table key val ]
-		if val, err = fromLuaValue(ctx); err != nil {
+		C.lua_pushvalue(ctx, -2) // [ ... table key val key ]
+		key, err = fromTableKey(ctx)
+		C.popN(ctx, 1) // [ ... table key val ]
+		if err != nil {
+			C.popN(ctx, 2) // [ ... talbe ]
+			return
+		}
+
+		cv.path = append(cv.path, key)
+		val, err = convertLuaValue(ctx, cv)
+		cv.path = cv.path[:len(cv.path)-1]
+		if err != nil {
 			C.popN(ctx, 2) // [ ... talbe ]
 			return
 		}
@@ -91,31 +132,135 @@ FOR_MAP:
 			val = fmt.Sprintf("%s", val) // deep copy
 		}
 		C.popN(ctx, 1) // [ ... table key ]
-		var key string
-		switch C.lua_type(ctx, -1) {
-		case C.LUA_TNUMBER:
-			idx := int(C.lua_tointegerx(ctx, -1, (*C.int)(unsafe.Pointer(nil))))
-			key = fmt.Sprintf("%d", idx)
-		case C.LUA_TSTRING:
-			var length C.size_t
-			s := C.lua_tolstring(ctx, -1, &length)
-			key = *(toString(s, int(length)))
-			// key = C.GoStringN(s, C.int(length))
+
+		switch k := key.(type) {
+		case string:
+			items.addKey(k, val)
+		case int64:
+			if k > 0 {
+				items.addIndex(k, val)
+			} else {
+				items.addInt(k, val)
+			}
 		default:
-			err = fmt.Errorf("key of string type expected")
-			C.popN(ctx, 1) // [ ... table ]
-			return
+			items.addAny(k, val)
+		}
+	}
+	if goVal, err = items.toValue(cv.policy, isArray); err != nil {
+		err = fmt.Errorf("%v at %s", err, cv.pathString())
+		return
+	}
+	cv.converted[p] = goVal
+	return
+}
+
+// the path is in format `$.name[1]["a key"]`
+func (cv *tableConverter) pathString() string {
+	b := &strings.Builder{}
+	b.WriteString("$")
+	for _, key := range cv.path {
+		switch k := key.(type) {
+		case string:
+			if isIdentifier(k) {
+				fmt.Fprintf(b, ".%s", k)
+			} else {
+				fmt.Fprintf(b, "[%q]", k)
+			}
+		default:
+			fmt.Fprintf(b, "[%v]", k)
 		}
-		res[key] = val
 	}
-	if maybeArr {
-		if len(arr) == 0 {
+	return b.String()
+}
+
+func isIdentifier(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for i, c := range s {
+		if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9') {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// fromTableKey converts a key of a table to string, int64, or other comparable Go value.
+func fromTableKey(ctx *C.lua_State) (goKey interface{}, err error) {
+	// [ ... key ]
+	switch C.lua_type(ctx, -1) {
+	case C.LUA_TSTRING:
+		var length C.size_t
+		s := C.lua_tolstring(ctx, -1, &length)
+		goKey = C.GoStringN(s, C.int(length))
+	case C.LUA_TNUMBER:
+		if C.lua_isinteger(ctx, -1) != 0 {
+			goKey = int64(C.lua_tointegerx(ctx, -1, (*C.int)(unsafe.Pointer(nil))))
+		} else {
+			goKey = float64(C.lua_tonumberx(ctx, -1, (*C.int)(unsafe.Pointer(nil))))
+		}
+	default:
+		return fromLuaKey(ctx)
+	}
+	return
+}
+
+func getStringKey(ctx *C.lua_State) (key string, err error) {
+	// [ ... key ]
+	switch C.lua_type(ctx, -1) {
+	case C.LUA_TNUMBER:
+		idx := int(C.lua_tointegerx(ctx, -1, (*C.int)(unsafe.Pointer(nil))))
+		key = fmt.Sprintf("%d", idx)
+	case C.LUA_TSTRING:
+		var length C.size_t
+		s := C.lua_tolstring(ctx, -1, &length)
+		key = *(toString(s, int(length)))
+		// key = C.GoStringN(s, C.int(length))
+	default:
+		err = fmt.Errorf("key of string type expected")
+	}
+	return
+}
+
+// fromLuaKey converts a key of a table neither integer nor string to a comparable Go value.
+func fromLuaKey(ctx *C.lua_State) (goKey interface{}, err error) {
+	// [ ... key ]
+	switch C.lua_type(ctx, -1) {
+	case C.LUA_TTABLE:
+		goKey = newLuaTable(ctx)
+	case C.LUA_TFUNCTION:
+		goKey = newLuaFunction(ctx)
+	default:
+		if goKey, err = fromLuaValue(ctx); err != nil {
 			return
 		}
-		goVal = arr
-	} else {
-		goVal = res
+		if goKey != nil && !reflect.TypeOf(goKey).Comparable() {
+			err = fmt.Errorf("key of type %T can't be used in Go map", goKey)
+		}
 	}
 	return
 }
 
+// fromLuaValueAs converts the value at the top of the stack for a Go var of type t.
+func fromLuaValueAs(ctx *C.lua_State, t reflect.Type) (goVal interface{}, err error) {
+	switch t {
+	case luaTableType:
+		if C.lua_type(ctx, -1) == C.LUA_TTABLE {
+			goVal = newLuaTable(ctx)
+		}
+		return
+	case luaFunctionType:
+		if C.lua_type(ctx, -1) == C.LUA_TFUNCTION {
+			goVal = newLuaFunction(ctx)
+		}
+		return
+	case luaThreadType:
+		if C.lua_type(ctx, -1) == C.LUA_TTHREAD {
+			goVal = newLuaThread(ctx)
+		}
+		return
+	default:
+		return fromLuaValue(ctx)
+	}
+}
@@ -0,0 +1,52 @@
+package lua
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTableCycles(t *testing.T) {
+	ctx, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = getGlobalOf(t, ctx, `(function() local t = {a = {b = {}}}; t.a.b.c = t.a; return t end)()`)
+	if err == nil || !strings.Contains(err.Error(), "cycle found at $.a.b.c") {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// a table referenced twice is not a cycle
+	v, err := getGlobalOf(t, ctx, `(function() local s = {x = 1}; return {a = s, b = s} end)()`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := v.(map[string]interface{})
+	a, b := m["a"].(map[string]interface{}), m["b"].(map[string]interface{})
+	if a["x"] != 1.0 || reflect.ValueOf(a).Pointer() != reflect.ValueOf(b).Pointer() {
+		t.Fatalf("the same map expected, got %v", v)
+	}
+}
+
+func TestTableLimits(t *testing.T) {
+	for _, c := range []struct{
+		policy TablePolicy
+		script string
+		err string
+	}{
+		{TablePolicy{MaxDepth: 2}, `{a = {b = {c = 1}}}`, "max depth 2 exceeded at $.a.b"},
+		{TablePolicy{MaxElements: 3}, `{1, 2, {3, 4}}`, "max elements 3 exceeded at $[3]"},
+	} {
+		ctx, err := NewContext(WithTablePolicy(c.policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = getGlobalOf(t, ctx, c.script); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("%s: unexpected error %v", c.script, err)
+		}
+		// the limits are not exceeded by the tables within them
+		if _, err = getGlobalOf(t, ctx, `{a = {1}}`); err != nil {
+			t.Fatal(err)
+		}
+	}
+}